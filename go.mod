@@ -3,14 +3,21 @@ module github.com/Krajiyah/nimble-interview-backend
 go 1.16
 
 require (
+	github.com/coreos/go-oidc/v3 v3.1.0
 	github.com/deckarep/golang-set v1.7.1
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gofrs/uuid v4.1.0+incompatible
+	github.com/golang-jwt/jwt/v4 v4.1.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/jackc/pgconn v1.10.0
+	github.com/jackc/pgx/v4 v4.13.0
 	github.com/labstack/echo/v4 v4.6.1
+	github.com/mattn/go-sqlite3 v1.14.8
 	github.com/pkg/errors v0.9.1
+	github.com/pquerna/otp v1.3.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/oauth2 v0.0.0-20211005180243-6b3c2da341f1
 	gorm.io/driver/postgres v1.1.2
 	gorm.io/driver/sqlite v1.1.6
 	gorm.io/gorm v1.21.16
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	channelNameIndex = "idx_channels_name_unique"
+)
+
+// addChannels also makes the names of live channels unique, so concurrent
+// creates cannot both take a name. Deleted channels give theirs up.
+func addChannels(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.Channel{}) {
+		if err := m.CreateTable(&models.Channel{}); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasTable(&models.ChannelMember{}) {
+		if err := m.CreateTable(&models.ChannelMember{}); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasColumn(&models.Message{}, "ChannelID") {
+		if err := m.AddColumn(&models.Message{}, "ChannelID"); err != nil {
+			return err
+		}
+		if err := m.CreateIndex(&models.Message{}, "ChannelID"); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasIndex(&models.Channel{}, channelNameIndex) {
+		if err := db.Exec("CREATE UNIQUE INDEX " + channelNameIndex + " ON channels (name) WHERE deleted_at IS NULL").Error; err != nil {
+			return err
+		}
+	}
+
+	general, err := models.GetChannelByName(db, models.DefaultChannelName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		general, err = models.NewChannel(db, &models.Channel{Name: models.DefaultChannelName})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return db.Model(&models.Message{}).
+		Where("channel_id IS NULL OR channel_id = 0").
+		Update("channel_id", general.ID).Error
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addPasswordResets(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.PasswordReset{}) {
+		if err := m.CreateTable(&models.PasswordReset{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
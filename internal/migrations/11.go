@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"net/mail"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	emailLowerIndex = "idx_users_email_lower"
+)
+
+// addUserEmails also takes over usernames that are email addresses as the
+// email of existing users, since password reset mail used to be sent to
+// them. They still have to be verified. Emails are unique regardless of
+// case, so it fails while emails of existing users only differ in case.
+func addUserEmails(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasColumn(&models.User{}, "Email") {
+		if err := addEmailColumns(db); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasIndex(&models.User{}, emailLowerIndex) {
+		if err := db.Exec("CREATE UNIQUE INDEX " + emailLowerIndex + " ON users (LOWER(email))").Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addEmailColumns(db *gorm.DB) error {
+	m := db.Migrator()
+
+	for _, field := range []string{"Email", "Verified"} {
+		if err := m.AddColumn(&models.User{}, field); err != nil {
+			return err
+		}
+	}
+	if err := m.CreateIndex(&models.User{}, "Email"); err != nil {
+		return err
+	}
+
+	users := []models.User{}
+	if err := db.Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		address, err := mail.ParseAddress(user.Username)
+		if err != nil || address.Address != user.Username {
+			continue
+		}
+		if err := db.Model(&user).Update("email", user.Username).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
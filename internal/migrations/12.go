@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addTwoFactor(db *gorm.DB) error {
+	m := db.Migrator()
+
+	for _, field := range []string{"TOTPSecret", "TOTPEnabled", "TOTPLastStep"} {
+		if !m.HasColumn(&models.User{}, field) {
+			if err := m.AddColumn(&models.User{}, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !m.HasTable(&models.RecoveryCode{}) {
+		if err := m.CreateTable(&models.RecoveryCode{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addIdentities(db *gorm.DB) error {
+	m := db.Migrator()
+
+	for _, model := range []interface{}{&models.Identity{}, &models.OIDCLogin{}} {
+		if !m.HasTable(model) {
+			if err := m.CreateTable(model); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
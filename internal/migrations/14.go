@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addAPIKeys(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.APIKey{}) {
+		if err := m.CreateTable(&models.APIKey{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
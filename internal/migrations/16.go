@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	usernameLowerIndex = "idx_users_username_lower"
+)
+
+// addUsernameIndex makes usernames unique regardless of case. It fails while
+// usernames of existing users only differ in case, one of them has to be
+// renamed first.
+func addUsernameIndex(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasIndex(&models.User{}, usernameLowerIndex) {
+		if err := db.Exec("CREATE UNIQUE INDEX " + usernameLowerIndex + " ON users (LOWER(username))").Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
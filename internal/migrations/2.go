@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	liveChannelNameIndex = "idx_channels_live_name_unique"
+)
+
+// addConversations leaves direct channels, which have no name, out of the
+// unique channel names.
+func addConversations(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasColumn(&models.Channel{}, "Direct") {
+		if err := m.AddColumn(&models.Channel{}, "Direct"); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasIndex(&models.Channel{}, liveChannelNameIndex) {
+		if err := db.Exec("CREATE UNIQUE INDEX " + liveChannelNameIndex + " ON channels (name) WHERE NOT direct AND deleted_at IS NULL").Error; err != nil {
+			return err
+		}
+	}
+	if m.HasIndex(&models.Channel{}, channelNameIndex) {
+		if err := m.DropIndex(&models.Channel{}, channelNameIndex); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasTable(&models.Conversation{}) {
+		if err := m.CreateTable(&models.Conversation{}); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasTable(&models.ReadPosition{}) {
+		if err := m.CreateTable(&models.ReadPosition{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
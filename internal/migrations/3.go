@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addThreads(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasColumn(&models.Message{}, "ParentID") {
+		if err := m.AddColumn(&models.Message{}, "ParentID"); err != nil {
+			return err
+		}
+		if err := m.CreateIndex(&models.Message{}, "ParentID"); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range []string{"ReplyCount", "LastReplyAt"} {
+		if !m.HasColumn(&models.Message{}, field) {
+			if err := m.AddColumn(&models.Message{}, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addMessageRevisions(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasColumn(&models.Message{}, "EditedAt") {
+		if err := m.AddColumn(&models.Message{}, "EditedAt"); err != nil {
+			return err
+		}
+	}
+
+	if !m.HasTable(&models.MessageRevision{}) {
+		if err := m.CreateTable(&models.MessageRevision{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addReactions(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.Reaction{}) {
+		if err := m.CreateTable(&models.Reaction{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	messageChannelReadIndex = "idx_messages_channel_id_id"
+)
+
+// addReadIndex lets unread counts seek straight to the messages after a read
+// position within a channel instead of scanning the whole channel.
+func addReadIndex(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasIndex(&models.Message{}, messageChannelReadIndex) {
+		if err := db.Exec("CREATE INDEX " + messageChannelReadIndex + " ON messages (channel_id, id)").Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	messageSearchIndex = "idx_messages_search"
+)
+
+// addMessageSearch indexes message data for full-text search. Postgres keeps a
+// generated tsvector column behind a GIN index, SQLite an external content
+// FTS5 table kept in sync by triggers.
+func addMessageSearch(db *gorm.DB) error {
+	if db.Dialector.Name() == "postgres" {
+		return addPostgresMessageSearch(db)
+	}
+	return addSqliteMessageSearch(db)
+}
+
+func addPostgresMessageSearch(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasColumn(&models.Message{}, models.MessageSearchColumn) {
+		if err := db.Exec("ALTER TABLE messages ADD COLUMN " + models.MessageSearchColumn + " tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(data, ''))) STORED").Error; err != nil {
+			return err
+		}
+	}
+
+	if !m.HasIndex(&models.Message{}, messageSearchIndex) {
+		if err := db.Exec("CREATE INDEX " + messageSearchIndex + " ON messages USING GIN (" + models.MessageSearchColumn + ")").Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addSqliteMessageSearch(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if m.HasTable(models.MessageSearchTable) {
+		return nil
+	}
+
+	err := db.Exec("CREATE VIRTUAL TABLE " + models.MessageSearchTable + " USING fts5(data, content='messages', content_rowid='id')").Error
+	if err != nil {
+		// go-sqlite3 only ships FTS5 when built with -tags sqlite_fts5, search
+		// falls back to LIKE without it
+		if strings.Contains(err.Error(), "no such module") {
+			return nil
+		}
+		return err
+	}
+
+	statements := []string{
+		`CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, data) VALUES (new.id, new.data);
+		END`,
+		`CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, data) VALUES ('delete', old.id, old.data);
+		END`,
+		`CREATE TRIGGER messages_fts_update AFTER UPDATE OF data ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, data) VALUES ('delete', old.id, old.data);
+			INSERT INTO messages_fts (rowid, data) VALUES (new.id, new.data);
+		END`,
+		"INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')",
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
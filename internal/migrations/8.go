@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addSessions(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.Session{}) {
+		if err := m.CreateTable(&models.Session{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
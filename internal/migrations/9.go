@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func addRevokedTokens(db *gorm.DB) error {
+	m := db.Migrator()
+
+	if !m.HasTable(&models.RevokedToken{}) {
+		if err := m.CreateTable(&models.RevokedToken{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
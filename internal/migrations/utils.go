@@ -12,6 +12,22 @@ type migration func(*gorm.DB) error
 var (
 	migrations = []migration{
 		initializeDB,
+		addChannels,
+		addConversations,
+		addThreads,
+		addMessageRevisions,
+		addReactions,
+		addReadIndex,
+		addMessageSearch,
+		addSessions,
+		addRevokedTokens,
+		addPasswordResets,
+		addUserEmails,
+		addTwoFactor,
+		addIdentities,
+		addAPIKeys,
+		addAttemptCounters,
+		addUsernameIndex,
 	}
 )
 
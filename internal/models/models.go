@@ -1,19 +1,271 @@
 package models
 
 import (
+	"database/sql/driver"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+const (
+	DefaultChannelName = "general"
+
+	MessageSearchColumn = "search"       // postgres tsvector column on messages
+	MessageSearchTable  = "messages_fts" // sqlite FTS5 table mirroring messages
+
+	SnippetStart = "<mark>"
+	SnippetStop  = "</mark>"
+
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeChannelsRead  = "channels:read"
+	ScopeChannelsWrite = "channels:write"
+
+	AuditLockout = "lockout"
+)
+
+// APIKeyScopes are all scopes an APIKey can be granted. Managing the account
+// itself is never allowed with an API key.
+var APIKeyScopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeChannelsRead, ScopeChannelsWrite}
+
 type User struct {
 	gorm.Model
-	Username     string `json:"username" gorm:"unique_index"`
-	PasswordHash string `json:"-"`
+	Username     string  `json:"username" gorm:"unique_index"`
+	PasswordHash string  `json:"-"`
+	Email        *string `json:"email" gorm:"uniqueIndex"`
+	Verified     bool    `json:"verified" gorm:"not null;default:false"` // whether Email was confirmed
+	TOTPSecret   *string `json:"-"`                                      // sealed, set on setup and kept once confirmed
+	TOTPEnabled  bool    `json:"totp_enabled" gorm:"not null;default:false"`
+	TOTPLastStep int64   `json:"-" gorm:"not null;default:0"` // last accepted TOTP time step, so codes cannot be replayed
 }
 
 type Message struct {
 	gorm.Model
-	Data     string `json:"data"`
-	Username string `json:"username"`
+	Data        string     `json:"data"`
+	Username    string     `json:"username"`
+	ChannelID   uint       `json:"channel_id" gorm:"index"`
+	ParentID    *uint      `json:"parent_id" gorm:"index"` // always a top-level message, threads are one level deep
+	ReplyCount  int        `json:"reply_count" gorm:"not null;default:0"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+	EditedAt    *time.Time `json:"edited_at"`
+}
+
+// MessageRevision keeps the content a message had before one of its edits.
+type MessageRevision struct {
+	gorm.Model
+	MessageID uint   `json:"message_id" gorm:"index"`
+	Data      string `json:"data"`
+}
+
+type Reaction struct {
+	MessageID uint      `json:"message_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey;index"`
+	Emoji     string    `json:"emoji" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+type Channel struct {
+	gorm.Model
+	Name      string `json:"name" gorm:"index"`
+	Topic     string `json:"topic"`
+	Private   bool   `json:"private"`
+	Direct    bool   `json:"direct" gorm:"not null;default:false"` // backs a Conversation, never listed or managed directly
+	CreatorID *uint  `json:"creator_id"`                           // nil for channels created by migrations
+}
+
+type ChannelMember struct {
+	ChannelID uint      `json:"channel_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Conversation is keyed by the ordered pair of its participants' IDs and
+// stores its messages in a private, direct Channel shared by the two.
+type Conversation struct {
+	gorm.Model
+	UserLowID  uint `json:"user_low_id" gorm:"uniqueIndex:idx_conversation_users"`
+	UserHighID uint `json:"user_high_id" gorm:"uniqueIndex:idx_conversation_users;index"`
+	ChannelID  uint `json:"channel_id"`
+}
+
+type ReadPosition struct {
+	ChannelID         uint      `json:"channel_id" gorm:"primaryKey"`
+	UserID            uint      `json:"user_id" gorm:"primaryKey"`
+	LastReadMessageID uint      `json:"last_read_message_id"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Session is one refresh token. Refreshing uses it up and issues the next
+// session of the same family, so replaying a used token reveals theft and
+// revokes the whole family.
+type Session struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	FamilyID  string     `json:"family_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// PasswordReset is a single-use token, emailed to the user, that lets them
+// set a new password without knowing the old one.
+type PasswordReset struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// RecoveryCode stands in for a TOTP code once, for users who lost their
+// authenticator.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `json:"user_id" gorm:"index"`
+	CodeHash string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// Identity links a user to their account at an OIDC provider.
+type Identity struct {
+	gorm.Model
+	UserID   uint   `json:"user_id" gorm:"index"`
+	Provider string `json:"provider" gorm:"uniqueIndex:idx_identity_subject"`
+	Subject  string `json:"subject" gorm:"uniqueIndex:idx_identity_subject"` // the provider's ID for the user
+	Email    string `json:"email"`
+}
+
+// OIDCLogin remembers a started OIDC login until the user comes back from
+// the provider. It is found by the login token, which only the app that
+// started the login knows, so an intercepted redirect is of no use.
+type OIDCLogin struct {
+	gorm.Model
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	Provider  string     `json:"provider"`
+	State     string     `json:"-"`
+	Nonce     string     `json:"-"`
+	Verifier  string     `json:"-"` // PKCE code verifier
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// APIKey lets scripts act as the user within its scopes without the user's
+// password. Only the hash of the key is stored.
+type APIKey struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // start of the key, to tell keys apart
+	KeyHash    string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     ScopeList  `json:"scopes" gorm:"type:text"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil for keys that never expire
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ScopeList is stored space separated, like OAuth2 scopes.
+type ScopeList []string
+
+func (scopes ScopeList) Value() (driver.Value, error) {
+	return strings.Join(scopes, " "), nil
+}
+
+func (scopes *ScopeList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*scopes = strings.Fields(v)
+	case []byte:
+		*scopes = strings.Fields(string(v))
+	default:
+		return errors.Errorf("cannot scan %T into ScopeList", value)
+	}
+	return nil
+}
+
+// Has reports whether every one of scopes is in the list.
+func (scopes ScopeList) Has(required ...string) bool {
+	for _, scope := range required {
+		found := false
+		for _, granted := range scopes {
+			if granted == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// AttemptCounter tracks failed attempts for a key, e.g. a username or an IP,
+// to lock out brute-force attacks.
+type AttemptCounter struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"` // within the window starting at WindowStart
+	WindowStart   time.Time
+	LastFailureAt time.Time `gorm:"index"`
+	Lockouts      int       `gorm:"not null;default:0"` // so far, each one longer than the last
+	LockedUntil   time.Time
+}
+
+// AuditEvent records security relevant events for later review.
+type AuditEvent struct {
+	gorm.Model
+	Event   string `json:"event" gorm:"index"`
+	UserID  *uint  `json:"user_id" gorm:"index"` // nil when no user is known, e.g. for unknown usernames
+	IP      string `json:"ip"`
+	Details string `json:"details"`
+}
+
+// RevokedToken remembers a logged out access token until it would have
+// expired anyway.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+}
+
+// MessageSearch narrows a full-text search, zero values are ignored.
+type MessageSearch struct {
+	Query    string
+	Username string
+	From     *time.Time
+	To       *time.Time
+}
+
+type MessageSearchResult struct {
+	Message
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"` // higher is a better match
+}
+
+func (conversation *Conversation) OtherUserID(userID uint) uint {
+	if conversation.UserLowID == userID {
+		return conversation.UserHighID
+	}
+	return conversation.UserLowID
+}
+
+func (channel *Channel) IsCreator(userID uint) bool {
+	return channel.CreatorID != nil && *channel.CreatorID == userID
+}
+
+func (channel *Channel) CanRead(db *gorm.DB, userID uint) (bool, error) {
+	if !channel.Private {
+		return true, nil
+	}
+	return IsChannelMember(db, channel.ID, userID)
 }
 
 func GetUserByID(db *gorm.DB, id uint) (*User, error) {
@@ -24,14 +276,69 @@ func GetUserByID(db *gorm.DB, id uint) (*User, error) {
 	return result, nil
 }
 
+// GetUserByUsername ignores case, since usernames are unique regardless of it.
 func GetUserByUsername(db *gorm.DB, username string) (*User, error) {
 	result := &User{}
-	if err := db.Where("username", username).First(result).Error; err != nil {
+	if err := db.Where("LOWER(username) = LOWER(?)", username).First(result).Error; err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
+func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
+	result := &User{}
+	if err := db.Where("LOWER(email) = LOWER(?)", email).First(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetUserEmail replaces the user's email, which then has to be verified again.
+func SetUserEmail(db *gorm.DB, user *User, email string) error {
+	return db.Model(user).Updates(map[string]interface{}{"email": email, "verified": false}).Error
+}
+
+// VerifyUserEmail only verifies the email if it is still the user's current
+// one, and reports whether it was.
+func VerifyUserEmail(db *gorm.DB, userID uint, email string) (bool, error) {
+	tx := db.Model(&User{}).Where("id = ? AND email = ?", userID, email).Update("verified", true)
+	return tx.RowsAffected == 1, tx.Error
+}
+
+// SetUserTOTPSecret starts a new TOTP setup, 2FA stays disabled until a code
+// for the secret is confirmed.
+func SetUserTOTPSecret(db *gorm.DB, user *User, sealedSecret string) error {
+	return db.Model(user).Updates(map[string]interface{}{"totp_secret": sealedSecret, "totp_enabled": false}).Error
+}
+
+// EnableUserTOTP replaces any previous recovery codes with the given ones.
+func EnableUserTOTP(db *gorm.DB, user *User, codeHashes []string) error {
+	if err := db.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := []RecoveryCode{}
+	for _, codeHash := range codeHashes {
+		codes = append(codes, RecoveryCode{UserID: user.ID, CodeHash: codeHash})
+	}
+	if err := db.Create(&codes).Error; err != nil {
+		return err
+	}
+	return db.Model(user).Update("totp_enabled", true).Error
+}
+
+// UseTOTPStep accepts each TOTP time step at most once, and reports whether
+// step was still unused.
+func UseTOTPStep(db *gorm.DB, userID uint, step int64) (bool, error) {
+	tx := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	return tx.RowsAffected == 1, tx.Error
+}
+
+// UseRecoveryCode reports whether the code was one of the user's unused ones.
+func UseRecoveryCode(db *gorm.DB, userID uint, codeHash string) (bool, error) {
+	tx := db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).Update("used_at", time.Now())
+	return tx.RowsAffected == 1, tx.Error
+}
+
 func NewUser(db *gorm.DB, result *User) (*User, error) {
 	return result, db.Create(result).Error
 }
@@ -39,3 +346,529 @@ func NewUser(db *gorm.DB, result *User) (*User, error) {
 func NewMessage(db *gorm.DB, result *Message) (*Message, error) {
 	return result, db.Create(result).Error
 }
+
+func GetMessageByID(db *gorm.DB, id uint) (*Message, error) {
+	result := &Message{}
+	if err := db.First(result, id).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddReply records a new reply on its top-level parent message.
+func AddReply(db *gorm.DB, reply *Message) error {
+	return db.Model(&Message{}).Where("id = ?", *reply.ParentID).Updates(map[string]interface{}{
+		"reply_count":   gorm.Expr("reply_count + 1"),
+		"last_reply_at": reply.CreatedAt,
+	}).Error
+}
+
+// DeleteReplies deletes the thread of a deleted top-level message.
+func DeleteReplies(db *gorm.DB, parent *Message) error {
+	return db.Where("parent_id = ?", parent.ID).Delete(&Message{}).Error
+}
+
+// RemoveReply undoes AddReply for a deleted reply, falling back to the
+// newest remaining reply for the parent's last reply time.
+func RemoveReply(db *gorm.DB, reply *Message) error {
+	var lastReplyAt *time.Time
+	latest := &Message{}
+	err := db.Where("parent_id = ? AND id <> ?", *reply.ParentID, reply.ID).Order("id DESC").First(latest).Error
+	switch {
+	case err == nil:
+		lastReplyAt = &latest.CreatedAt
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	return db.Model(&Message{}).Where("id = ?", *reply.ParentID).Updates(map[string]interface{}{
+		"reply_count":   gorm.Expr("reply_count - 1"),
+		"last_reply_at": lastReplyAt,
+	}).Error
+}
+
+// EditMessage saves the current content as a revision before replacing it.
+func EditMessage(db *gorm.DB, message *Message, data string) error {
+	if err := db.Create(&MessageRevision{MessageID: message.ID, Data: message.Data}).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	message.Data = data
+	message.EditedAt = &now
+	return db.Model(message).Select("data", "edited_at").Updates(message).Error
+}
+
+// AddReaction is idempotent per user and emoji, even for concurrent requests.
+// Like RemoveReaction it bumps the message's UpdatedAt so pollers notice the
+// change.
+func AddReaction(db *gorm.DB, message *Message, userID uint, emoji string) error {
+	reaction := &Reaction{MessageID: message.ID, UserID: userID, Emoji: emoji}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+	// nothing is inserted when the user already reacted with the emoji
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return db.Model(message).Update("updated_at", time.Now()).Error
+}
+
+func RemoveReaction(db *gorm.DB, message *Message, userID uint, emoji string) error {
+	result := db.Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, userID, emoji).Delete(&Reaction{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return db.Model(message).Update("updated_at", time.Now()).Error
+}
+
+// GetReactionSummaries maps each message ID to its reaction counts in a
+// single grouped query.
+func GetReactionSummaries(db *gorm.DB, userID uint, messageIDs []uint) (map[uint][]ReactionSummary, error) {
+	rows := []struct {
+		MessageID uint
+		Emoji     string
+		Count     int64
+		Mine      int64
+	}{}
+	err := db.Model(&Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, SUM(CASE WHEN user_id = ? THEN 1 ELSE 0 END) AS mine", userID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id, emoji").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := map[uint][]ReactionSummary{}
+	for _, row := range rows {
+		result[row.MessageID] = append(result[row.MessageID], ReactionSummary{Emoji: row.Emoji, Count: row.Count, ReactedByMe: row.Mine > 0})
+	}
+	return result, nil
+}
+
+func GetChannelByID(db *gorm.DB, id uint) (*Channel, error) {
+	result := &Channel{}
+	if err := db.First(result, id).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func GetChannelByName(db *gorm.DB, name string) (*Channel, error) {
+	result := &Channel{}
+	if err := db.Where("name", name).First(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func NewChannel(db *gorm.DB, result *Channel) (*Channel, error) {
+	return result, db.Create(result).Error
+}
+
+func CanReadChannelByID(db *gorm.DB, channelID uint, userID uint) (bool, error) {
+	channel, err := GetChannelByID(db, channelID)
+	if err != nil {
+		return false, err
+	}
+	return channel.CanRead(db, userID)
+}
+
+// ReadableChannelIDs is a subquery selecting every channel the user may read:
+// all public channels plus the private ones they are a member of.
+func ReadableChannelIDs(db *gorm.DB, userID uint) *gorm.DB {
+	memberships := db.Model(&ChannelMember{}).Select("channel_id").Where("user_id = ?", userID)
+	return db.Model(&Channel{}).Select("id").Where("private = ? OR id IN (?)", false, memberships)
+}
+
+func IsChannelMember(db *gorm.DB, channelID uint, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&ChannelMember{}).Where("channel_id = ? AND user_id = ?", channelID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func AddChannelMember(db *gorm.DB, channelID uint, userID uint) error {
+	member := &ChannelMember{ChannelID: channelID, UserID: userID}
+	return db.Where(member).FirstOrCreate(member).Error
+}
+
+func RemoveChannelMember(db *gorm.DB, channelID uint, userID uint) error {
+	return db.Where("channel_id = ? AND user_id = ?", channelID, userID).Delete(&ChannelMember{}).Error
+}
+
+func GetChannelMembers(db *gorm.DB, channelID uint) *gorm.DB {
+	return db.Model(&User{}).
+		Joins("JOIN channel_members ON channel_members.user_id = users.id").
+		Where("channel_members.channel_id = ?", channelID).
+		Order("users.id")
+}
+
+func conversationKey(userID uint, otherUserID uint) (uint, uint) {
+	if userID < otherUserID {
+		return userID, otherUserID
+	}
+	return otherUserID, userID
+}
+
+func GetConversation(db *gorm.DB, userID uint, otherUserID uint) (*Conversation, error) {
+	low, high := conversationKey(userID, otherUserID)
+	result := &Conversation{}
+	if err := db.Where("user_low_id = ? AND user_high_id = ?", low, high).First(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// NewConversation creates the conversation together with its backing channel
+// and memberships, so it should be called inside a transaction.
+func NewConversation(db *gorm.DB, userID uint, otherUserID uint) (*Conversation, error) {
+	channel, err := NewChannel(db, &Channel{Private: true, Direct: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []uint{userID, otherUserID} {
+		if err := AddChannelMember(db, channel.ID, id); err != nil {
+			return nil, err
+		}
+	}
+	low, high := conversationKey(userID, otherUserID)
+	result := &Conversation{UserLowID: low, UserHighID: high, ChannelID: channel.ID}
+	return result, db.Create(result).Error
+}
+
+func GetConversationsForUser(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&Conversation{}).Where("user_low_id = ? OR user_high_id = ?", userID, userID)
+}
+
+// GetLatestMessages maps each channel ID to its newest message.
+func GetLatestMessages(db *gorm.DB, channelIDs []uint) (map[uint]Message, error) {
+	latestIDs := db.Model(&Message{}).Select("MAX(id)").Where("channel_id IN ?", channelIDs).Group("channel_id")
+	messages := []Message{}
+	if err := db.Where("id IN (?)", latestIDs).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	result := map[uint]Message{}
+	for _, message := range messages {
+		result[message.ChannelID] = message
+	}
+	return result, nil
+}
+
+// GetUnreadCounts maps each channel ID to the number of messages posted by
+// others after the user's read position in that channel.
+func GetUnreadCounts(db *gorm.DB, user *User, channelIDs []uint) (map[uint]int64, error) {
+	rows := []struct {
+		ChannelID uint
+		Count     int64
+	}{}
+	err := db.Model(&Message{}).
+		Select("messages.channel_id, COUNT(*) AS count").
+		Joins("LEFT JOIN read_positions ON read_positions.channel_id = messages.channel_id AND read_positions.user_id = ?", user.ID).
+		Where("messages.channel_id IN ?", channelIDs).
+		Where("messages.id > COALESCE(read_positions.last_read_message_id, 0)").
+		Where("messages.username <> ?", user.Username).
+		Group("messages.channel_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := map[uint]int64{}
+	for _, row := range rows {
+		result[row.ChannelID] = row.Count
+	}
+	return result, nil
+}
+
+// GetReadPositions maps each channel ID to the user's last read message ID.
+func GetReadPositions(db *gorm.DB, userID uint, channelIDs []uint) (map[uint]uint, error) {
+	positions := []ReadPosition{}
+	if err := db.Where("user_id = ? AND channel_id IN ?", userID, channelIDs).Find(&positions).Error; err != nil {
+		return nil, err
+	}
+	result := map[uint]uint{}
+	for _, position := range positions {
+		result[position.ChannelID] = position.LastReadMessageID
+	}
+	return result, nil
+}
+
+// MarkChannelRead only ever moves the user's read position forward, in a
+// single upsert so late or concurrent receipts cannot move it backwards.
+func MarkChannelRead(db *gorm.DB, channelID uint, userID uint, messageID uint) error {
+	greatest := "MAX"
+	if db.Dialector.Name() == "postgres" {
+		greatest = "GREATEST"
+	}
+	now := time.Now()
+	position := &ReadPosition{ChannelID: channelID, UserID: userID, LastReadMessageID: messageID, UpdatedAt: now}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "channel_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_read_message_id": gorm.Expr(greatest+"(read_positions.last_read_message_id, ?)", messageID),
+			"updated_at":           gorm.Expr("CASE WHEN read_positions.last_read_message_id < ? THEN ? ELSE read_positions.updated_at END", messageID, now),
+		}),
+	}).Create(position).Error
+}
+
+func NewSession(db *gorm.DB, result *Session) (*Session, error) {
+	return result, db.Create(result).Error
+}
+
+func GetSessionByTokenHash(db *gorm.DB, tokenHash string) (*Session, error) {
+	result := &Session{}
+	if err := db.Where("token_hash = ?", tokenHash).First(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UseSession marks the session used and reports whether this call was the one
+// that did, so two concurrent refreshes cannot both succeed.
+func UseSession(db *gorm.DB, session *Session) (bool, error) {
+	now := time.Now()
+	tx := db.Model(&Session{}).Where("id = ? AND used_at IS NULL", session.ID).Update("used_at", now)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	session.UsedAt = &now
+	return tx.RowsAffected == 1, nil
+}
+
+func RevokeSessionFamily(db *gorm.DB, familyID string) error {
+	return db.Model(&Session{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions revokes every session family of the user.
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", time.Now()).Error
+}
+
+// RevokeToken also prunes tokens that have expired since being revoked, which
+// keeps the table about as large as the number of recent logouts.
+func RevokeToken(db *gorm.DB, jti string, expiresAt time.Time) error {
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	token := &RevokedToken{JTI: jti}
+	return db.Where(token).Attrs(RevokedToken{ExpiresAt: expiresAt}).FirstOrCreate(token).Error
+}
+
+// IsTokenRevoked reports whether the token was logged out or belongs to a
+// revoked session family.
+func IsTokenRevoked(db *gorm.DB, jti string, sessionID string) (bool, error) {
+	var count int64
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 && sessionID != "" {
+		err := db.Model(&Session{}).Where("family_id = ? AND revoked_at IS NOT NULL", sessionID).Count(&count).Error
+		if err != nil {
+			return false, err
+		}
+	}
+	return count > 0, nil
+}
+
+func NewPasswordReset(db *gorm.DB, result *PasswordReset) (*PasswordReset, error) {
+	return result, db.Create(result).Error
+}
+
+// UsePasswordReset finds the unused, unexpired reset for the token hash and
+// uses it up along with every other outstanding reset of the same user.
+func UsePasswordReset(db *gorm.DB, tokenHash string) (*PasswordReset, error) {
+	result := &PasswordReset{}
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(result).Error
+	if err != nil {
+		return nil, err
+	}
+	tx := db.Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", result.UserID).Update("used_at", time.Now())
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound // used concurrently
+	}
+	return result, nil
+}
+
+func NewIdentity(db *gorm.DB, result *Identity) (*Identity, error) {
+	return result, db.Create(result).Error
+}
+
+func GetIdentity(db *gorm.DB, provider string, subject string) (*Identity, error) {
+	result := &Identity{}
+	if err := db.Where("provider = ? AND subject = ?", provider, subject).First(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func NewOIDCLogin(db *gorm.DB, result *OIDCLogin) (*OIDCLogin, error) {
+	return result, db.Create(result).Error
+}
+
+// UseOIDCLogin finds the unused, unexpired login for the token hash and uses
+// it up, so each login can only be finished once.
+func UseOIDCLogin(db *gorm.DB, tokenHash string) (*OIDCLogin, error) {
+	result := &OIDCLogin{}
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(result).Error
+	if err != nil {
+		return nil, err
+	}
+	tx := db.Model(result).Where("used_at IS NULL").Update("used_at", time.Now())
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound // used concurrently
+	}
+	return result, nil
+}
+
+func NewAPIKey(db *gorm.DB, result *APIKey) (*APIKey, error) {
+	return result, db.Create(result).Error
+}
+
+// GetAPIKeyByHash only finds keys that have not expired or been deleted.
+func GetAPIKeyByHash(db *gorm.DB, keyHash string) (*APIKey, error) {
+	result := &APIKey{}
+	err := db.Where("key_hash = ? AND (expires_at IS NULL OR expires_at > ?)", keyHash, time.Now()).First(result).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func GetAPIKeysForUser(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&APIKey{}).Where("user_id = ?", userID).Order("id DESC")
+}
+
+// DeleteAPIKey reports whether the user had a key with the ID to delete.
+func DeleteAPIKey(db *gorm.DB, userID uint, id uint) (bool, error) {
+	tx := db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	return tx.RowsAffected == 1, tx.Error
+}
+
+// DeleteUserAPIKeys deletes every key of the user, e.g. when their password
+// changes since a key may have been created by whoever knew the old one.
+func DeleteUserAPIKeys(db *gorm.DB, userID uint) error {
+	return db.Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
+
+// TouchAPIKey records that the key was used, at most once per interval so
+// busy scripts do not write on every request.
+func TouchAPIKey(db *gorm.DB, key *APIKey, interval time.Duration) error {
+	now := time.Now()
+	return db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
+
+// GetAttemptCounter returns an empty counter for keys without failures.
+func GetAttemptCounter(db *gorm.DB, key string) (*AttemptCounter, error) {
+	result := &AttemptCounter{Key: key}
+	if err := db.Where(&AttemptCounter{Key: key}).FirstOrInit(result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FailAttemptCounter counts a failure of the key at now in a single upsert, so
+// concurrent failures all count. Failures restart at one once the window has
+// passed, and lockouts are forgotten forgetAfter the last failure.
+func FailAttemptCounter(db *gorm.DB, key string, now time.Time, window time.Duration, forgetAfter time.Duration) (*AttemptCounter, error) {
+	windowStart, forgetBefore := now.Add(-window), now.Add(-forgetAfter)
+	counter := &AttemptCounter{Key: key, Failures: 1, WindowStart: now, LastFailureAt: now}
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN attempt_counters.window_start <= ? THEN 1 ELSE attempt_counters.failures + 1 END", windowStart),
+			"window_start":    gorm.Expr("CASE WHEN attempt_counters.window_start <= ? THEN ? ELSE attempt_counters.window_start END", windowStart, now),
+			"lockouts":        gorm.Expr("CASE WHEN attempt_counters.last_failure_at <= ? THEN 0 ELSE attempt_counters.lockouts END", forgetBefore),
+			"last_failure_at": now,
+		}),
+	}).Create(counter).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetAttemptCounter(db, key)
+}
+
+// LockAttemptCounter locks the counter's key out until until and restarts its
+// window, unless its lockouts changed since it was read, i.e. a concurrent
+// failure locked it out first. It reports whether it locked the key out.
+func LockAttemptCounter(db *gorm.DB, counter *AttemptCounter, now time.Time, until time.Time) (bool, error) {
+	tx := db.Model(&AttemptCounter{}).
+		Where(map[string]interface{}{"key": counter.Key, "lockouts": counter.Lockouts}).
+		Updates(map[string]interface{}{"failures": 0, "window_start": now, "lockouts": counter.Lockouts + 1, "locked_until": until})
+	return tx.RowsAffected == 1, tx.Error
+}
+
+func DeleteAttemptCounter(db *gorm.DB, key string) error {
+	return db.Where(&AttemptCounter{Key: key}).Delete(&AttemptCounter{}).Error
+}
+
+// PruneAttemptCounters forgets counters without failures since before.
+func PruneAttemptCounters(db *gorm.DB, before time.Time) error {
+	return db.Where("last_failure_at < ?", before).Delete(&AttemptCounter{}).Error
+}
+
+func NewAuditEvent(db *gorm.DB, result *AuditEvent) (*AuditEvent, error) {
+	return result, db.Create(result).Error
+}
+
+// SearchMessages selects the messages the user may read that match every term
+// of search.Query, best match first, as MessageSearchResults.
+func SearchMessages(db *gorm.DB, userID uint, search *MessageSearch) *gorm.DB {
+	tx := db.Table("messages")
+	if db.Dialector.Name() == "postgres" {
+		query := "plainto_tsquery('english', ?)"
+		options := "StartSel=" + SnippetStart + ", StopSel=" + SnippetStop
+		tx = tx.
+			Select("messages.*, ts_rank(messages."+MessageSearchColumn+", "+query+") AS score, ts_headline('english', messages.data, "+query+", ?) AS snippet", search.Query, search.Query, options).
+			Where("messages."+MessageSearchColumn+" @@ "+query, search.Query)
+	} else if !db.Migrator().HasTable(MessageSearchTable) {
+		// go-sqlite3 was built without FTS5, scan for every term instead
+		tx = tx.Select("messages.*, 0 AS score, messages.data AS snippet")
+		terms := searchTerms(search.Query)
+		if len(terms) == 0 {
+			tx = tx.Where("1 = 0")
+		}
+		for _, term := range terms {
+			// terms are letters and numbers only, so never LIKE wildcards
+			tx = tx.Where("messages.data LIKE ?", "%"+term+"%")
+		}
+	} else {
+		// bm25 is lower for better matches
+		tx = tx.
+			Select("messages.*, -bm25("+MessageSearchTable+") AS score, snippet("+MessageSearchTable+", 0, ?, ?, '...', 16) AS snippet", SnippetStart, SnippetStop).
+			Joins("JOIN "+MessageSearchTable+" ON "+MessageSearchTable+".rowid = messages.id").
+			Where(MessageSearchTable+" MATCH ?", ftsQuery(search.Query))
+	}
+
+	// db.Table skips the soft delete scope of Message
+	tx = tx.Where("messages.deleted_at IS NULL AND messages.channel_id IN (?)", ReadableChannelIDs(db, userID))
+	if search.Username != "" {
+		tx = tx.Where("messages.username = ?", search.Username)
+	}
+	if search.From != nil {
+		tx = tx.Where("messages.created_at >= ?", *search.From)
+	}
+	if search.To != nil {
+		tx = tx.Where("messages.created_at < ?", *search.To)
+	}
+	return tx.Order("score DESC").Order("messages.id DESC")
+}
+
+// ftsQuery quotes every word of the user's query so FTS5 operators in it are
+// matched literally, the terms are implicitly ANDed like plainto_tsquery.
+func ftsQuery(query string) string {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return `""`
+	}
+	return `"` + strings.Join(terms, `" "`) + `"`
+}
+
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
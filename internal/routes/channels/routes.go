@@ -0,0 +1,370 @@
+package channels
+
+import (
+	"net/http"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+type channelInput struct {
+	Name    *string `json:"name"`
+	Topic   *string `json:"topic"`
+	Private *bool   `json:"private"`
+}
+
+type memberInput struct {
+	Username string `json:"username"`
+}
+
+type CreateChannelAPI struct {
+	deps utils.Deps
+}
+
+func NewCreateChannelAPI(deps utils.Deps) utils.Route {
+	return &CreateChannelAPI{deps}
+}
+
+func (api *CreateChannelAPI) Method() string { return http.MethodPost }
+func (api *CreateChannelAPI) Path() string   { return "/channels" }
+func (api *CreateChannelAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsWrite)}
+}
+
+func (api *CreateChannelAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "CreateChannelAPI", "user": user})
+
+	var input channelInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Name == nil || *input.Name == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	channel := &models.Channel{Name: *input.Name, CreatorID: &user.ID}
+	if input.Topic != nil {
+		channel.Topic = *input.Topic
+	}
+	if input.Private != nil {
+		channel.Private = *input.Private
+	}
+
+	db := api.deps.DB().Begin()
+	channel, err := models.NewChannel(db, channel)
+	if utils.IsUniqueViolation(err) {
+		db.Rollback()
+		logger.Warn("channel already exists")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not create channel")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.AddChannelMember(db, channel.ID, user.ID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not add creator to channel")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for channel creation")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("channel", channel.ID).Debug("channel created")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(channel))
+}
+
+type GetChannelsAPI struct {
+	deps utils.Deps
+}
+
+func NewGetChannelsAPI(deps utils.Deps) utils.Route {
+	return &GetChannelsAPI{deps}
+}
+
+func (api *GetChannelsAPI) Method() string { return http.MethodGet }
+func (api *GetChannelsAPI) Path() string   { return "/channels" }
+func (api *GetChannelsAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsRead)}
+}
+
+func (api *GetChannelsAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetChannelsAPI", "user": user})
+
+	channels := []models.Channel{}
+	db := api.deps.DB().
+		Where("id IN (?) AND direct = ?", models.ReadableChannelIDs(api.deps.DB(), user.ID), false).
+		Order("id")
+	pagination, err := utils.NewPaginator(c).Find(db, &channels)
+	if err != nil {
+		logger.WithError(err).Error("could not get channels")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("channelCount", len(channels)).Debug("got channels")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, channels, pagination))
+}
+
+type GetChannelAPI struct {
+	deps utils.Deps
+}
+
+func NewGetChannelAPI(deps utils.Deps) utils.Route {
+	return &GetChannelAPI{deps}
+}
+
+func (api *GetChannelAPI) Method() string { return http.MethodGet }
+func (api *GetChannelAPI) Path() string   { return "/channels/:id" }
+func (api *GetChannelAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsRead), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *GetChannelAPI) Handler(c echo.Context) error {
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(middlewares.RequireChannel(c)))
+}
+
+type UpdateChannelAPI struct {
+	deps utils.Deps
+}
+
+func NewUpdateChannelAPI(deps utils.Deps) utils.Route {
+	return &UpdateChannelAPI{deps}
+}
+
+func (api *UpdateChannelAPI) Method() string { return http.MethodPatch }
+func (api *UpdateChannelAPI) Path() string   { return "/channels/:id" }
+func (api *UpdateChannelAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsWrite), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *UpdateChannelAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "UpdateChannelAPI", "user": user, "channel": channel.ID})
+
+	if !channel.IsCreator(user.ID) {
+		logger.Warn("only the creator may update a channel")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	var input channelInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Name != nil && *input.Name != channel.Name {
+		if *input.Name == "" {
+			logger.Warn("missing parameters")
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+		}
+		channel.Name = *input.Name
+	}
+	if input.Topic != nil {
+		channel.Topic = *input.Topic
+	}
+	accessChanged := input.Private != nil && *input.Private != channel.Private
+	if input.Private != nil {
+		channel.Private = *input.Private
+	}
+
+	err := api.deps.DB().Save(channel).Error
+	if utils.IsUniqueViolation(err) {
+		logger.Warn("channel already exists")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+	if err != nil {
+		logger.WithError(err).Error("could not update channel")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if accessChanged {
+		if err := utils.PublishChannelAccessChanged(api.deps.PubSub(), channel.ID); err != nil {
+			logger.WithError(err).Warn("could not publish channel access changed event")
+		}
+	}
+
+	logger.Debug("channel updated")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(channel))
+}
+
+type DeleteChannelAPI struct {
+	deps utils.Deps
+}
+
+func NewDeleteChannelAPI(deps utils.Deps) utils.Route {
+	return &DeleteChannelAPI{deps}
+}
+
+func (api *DeleteChannelAPI) Method() string { return http.MethodDelete }
+func (api *DeleteChannelAPI) Path() string   { return "/channels/:id" }
+func (api *DeleteChannelAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsWrite), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *DeleteChannelAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "DeleteChannelAPI", "user": user, "channel": channel.ID})
+
+	if !channel.IsCreator(user.ID) {
+		logger.Warn("only the creator may delete a channel")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	if err := api.deps.DB().Delete(channel).Error; err != nil {
+		logger.WithError(err).Error("could not delete channel")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("channel deleted")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(channel))
+}
+
+type GetChannelMembersAPI struct {
+	deps utils.Deps
+}
+
+func NewGetChannelMembersAPI(deps utils.Deps) utils.Route {
+	return &GetChannelMembersAPI{deps}
+}
+
+func (api *GetChannelMembersAPI) Method() string { return http.MethodGet }
+func (api *GetChannelMembersAPI) Path() string   { return "/channels/:id/members" }
+func (api *GetChannelMembersAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsRead), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *GetChannelMembersAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetChannelMembersAPI", "user": user, "channel": channel.ID})
+
+	members := []models.User{}
+	pagination, err := utils.NewPaginator(c).Find(models.GetChannelMembers(api.deps.DB(), channel.ID), &members)
+	if err != nil {
+		logger.WithError(err).Error("could not get channel members")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("memberCount", len(members)).Debug("got channel members")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, members, pagination))
+}
+
+// AddChannelMemberAPI lets anyone join or invite others to a public channel,
+// while private channels only accept invitations from existing members.
+type AddChannelMemberAPI struct {
+	deps utils.Deps
+}
+
+func NewAddChannelMemberAPI(deps utils.Deps) utils.Route {
+	return &AddChannelMemberAPI{deps}
+}
+
+func (api *AddChannelMemberAPI) Method() string { return http.MethodPost }
+func (api *AddChannelMemberAPI) Path() string   { return "/channels/:id/members" }
+func (api *AddChannelMemberAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsWrite), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *AddChannelMemberAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "AddChannelMemberAPI", "user": user, "channel": channel.ID})
+
+	if channel.Direct {
+		logger.Warn("cannot change members of a direct channel")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	var input memberInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Username == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	member, err := models.GetUserByUsername(api.deps.DB(), input.Username)
+	if err != nil {
+		logger.WithError(err).Warn("could not find user w/ username")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	if err := models.AddChannelMember(api.deps.DB(), channel.ID, member.ID); err != nil {
+		logger.WithError(err).Error("could not add channel member")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := utils.PublishChannelAccessChanged(api.deps.PubSub(), channel.ID); err != nil {
+		// open streams keep their cached access until the next change
+		logger.WithError(err).Warn("could not publish channel access changed event")
+	}
+
+	logger.WithField("member", member.ID).Debug("channel member added")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(member))
+}
+
+type RemoveChannelMemberAPI struct {
+	deps utils.Deps
+}
+
+func NewRemoveChannelMemberAPI(deps utils.Deps) utils.Route {
+	return &RemoveChannelMemberAPI{deps}
+}
+
+func (api *RemoveChannelMemberAPI) Method() string { return http.MethodDelete }
+func (api *RemoveChannelMemberAPI) Path() string   { return "/channels/:id/members/:username" }
+func (api *RemoveChannelMemberAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeChannelsWrite), middlewares.ChannelAccessMiddleware(api.deps)}
+}
+
+func (api *RemoveChannelMemberAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "RemoveChannelMemberAPI", "user": user, "channel": channel.ID})
+
+	if channel.Direct {
+		logger.Warn("cannot change members of a direct channel")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	member, err := models.GetUserByUsername(api.deps.DB(), c.Param("username"))
+	if err != nil {
+		logger.WithError(err).Warn("could not find user w/ username")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	if member.ID != user.ID && !channel.IsCreator(user.ID) {
+		logger.Warn("only the creator may remove other members")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	if err := models.RemoveChannelMember(api.deps.DB(), channel.ID, member.ID); err != nil {
+		logger.WithError(err).Error("could not remove channel member")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := utils.PublishChannelAccessChanged(api.deps.PubSub(), channel.ID); err != nil {
+		// open streams keep their cached access until the next change
+		logger.WithError(err).Warn("could not publish channel access changed event")
+	}
+
+	logger.WithField("member", member.ID).Debug("channel member removed")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(member))
+}
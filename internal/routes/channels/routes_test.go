@@ -0,0 +1,175 @@
+package channels
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/messages"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := newTestServer(deps)
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/channels", token, `{"name": "someChannel", "topic": "someTopic"}`)
+	require.Equal(t, http.StatusOK, status, res.Error)
+	channel := res.Result.(map[string]interface{})
+	require.Equal(t, "someChannel", channel["name"])
+	require.Equal(t, "someTopic", channel["topic"])
+	require.Equal(t, false, channel["private"])
+	path := fmt.Sprintf("/channels/%v", channel["ID"])
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/channels", token, `{"name": "someChannel"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/channels", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 2) // general + someChannel
+
+	status, res = testutils.DoRequest(t, server, http.MethodPatch, path, token, `{"topic": "someOtherTopic"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "someOtherTopic", res.Result.(map[string]interface{})["topic"])
+	require.Equal(t, "someChannel", res.Result.(map[string]interface{})["name"])
+	status, _ = testutils.DoRequest(t, server, http.MethodPatch, path, token, `{"name": "general"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, path+"/messages", token, `{"data": "some message data"}`)
+	require.Equal(t, http.StatusOK, status)
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"/messages", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 1)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, path, token, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodGet, path, token, "")
+	require.Equal(t, http.StatusNotFound, status)
+
+	// deleted channels give up their name
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/channels", token, `{"name": "someChannel"}`)
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestPrivateChannelAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := newTestServer(deps)
+	defer server.Close()
+
+	_, ownerToken := testutils.NewUserWithToken(t, deps, "someOwner")
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUser")
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/channels", ownerToken, `{"name": "somePrivateChannel", "private": true}`)
+	require.Equal(t, http.StatusOK, status)
+	channelID := uint(res.Result.(map[string]interface{})["ID"].(float64))
+	path := fmt.Sprintf("/channels/%d", channelID)
+
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodGet, path, ""},
+		{http.MethodGet, path + "/members", ""},
+		{http.MethodPost, path + "/members", `{"username": "someOtherUser"}`},
+		{http.MethodGet, path + "/messages", ""},
+		{http.MethodPost, path + "/messages", `{"data": "some message data"}`},
+	} {
+		status, _ = testutils.DoRequest(t, server, route.method, route.path, otherToken, route.body)
+		require.Equal(t, http.StatusForbidden, status, route.method+" "+route.path)
+	}
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/channels", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 1) // only general
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, path+"/members", ownerToken, `{"username": "someOtherUser"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, uint64(1), deps.Hub().ChannelAccessVersion(channelID)) // open streams check again
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"/members", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 2)
+	require.Equal(t, int64(2), res.Total)
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"/members?page=2&page_size=1", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "someOtherUser", res.Result.([]interface{})[0].(map[string]interface{})["username"])
+	require.False(t, res.HasMore)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, path+"/messages", otherToken, `{"data": "some message data"}`)
+	require.Equal(t, http.StatusOK, status)
+
+	// only the creator may change the channel or remove others
+	status, _ = testutils.DoRequest(t, server, http.MethodPatch, path, otherToken, `{"private": false}`)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, path+"/members/someOwner", otherToken, "")
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, path+"/members/someOtherUser", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, uint64(2), deps.Hub().ChannelAccessVersion(channelID))
+	status, _ = testutils.DoRequest(t, server, http.MethodGet, path+"/messages", otherToken, "")
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestGetChannelsAPIPagination(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := newTestServer(deps)
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	i := 0
+	for i < 4 {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/channels", token, fmt.Sprintf(`{"name": "someChannel%d"}`, i))
+		require.Equal(t, http.StatusOK, status)
+		i++
+	}
+
+	status, res := testutils.DoRequest(t, server, http.MethodGet, "/channels?page=2&page_size=2", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 2)
+	require.Equal(t, 2, res.Page)
+	require.Equal(t, 2, res.PageSize)
+	require.Equal(t, int64(5), res.Total) // general + 4
+	require.True(t, res.HasMore)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/channels?page=3&page_size=2", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 1)
+	require.False(t, res.HasMore)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/channels?page=2&page_size=2", nil)
+	require.NoError(t, err)
+	r.Header.Set(middlewares.JwtRequestHeader, token)
+	w, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer w.Body.Close()
+	require.Equal(t, `</channels?page=3&page_size=2>; rel="next", `+
+		`</channels?page=1&page_size=2>; rel="prev", `+
+		`</channels?page=1&page_size=2>; rel="first", `+
+		`</channels?page=3&page_size=2>; rel="last"`, w.Header.Get("Link"))
+}
+
+func newTestServer(deps utils.Deps) *httptest.Server {
+	return httptest.NewServer(utils.NewServer([]utils.Route{
+		NewCreateChannelAPI(deps),
+		NewGetChannelsAPI(deps),
+		NewGetChannelAPI(deps),
+		NewUpdateChannelAPI(deps),
+		NewDeleteChannelAPI(deps),
+		NewGetChannelMembersAPI(deps),
+		NewAddChannelMemberAPI(deps),
+		NewRemoveChannelMemberAPI(deps),
+		messages.NewSendMessageAPI(deps),
+		messages.NewGetMessagesAPI(deps),
+	}))
+}
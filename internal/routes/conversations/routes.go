@@ -0,0 +1,257 @@
+package conversations
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type messageInput struct {
+	Data string `json:"data"`
+}
+
+const conversationSavePoint = "conversation"
+
+type conversationResponse struct {
+	ID            uint            `json:"id"`
+	ChannelID     uint            `json:"channel_id"`
+	User          *models.User    `json:"user"`
+	LatestMessage *models.Message `json:"latest_message"`
+	UnreadCount   int64           `json:"unread_count"`
+}
+
+type SendDirectMessageAPI struct {
+	deps utils.Deps
+}
+
+func NewSendDirectMessageAPI(deps utils.Deps) utils.Route {
+	return &SendDirectMessageAPI{deps}
+}
+
+func (api *SendDirectMessageAPI) Method() string { return http.MethodPost }
+func (api *SendDirectMessageAPI) Path() string   { return "/users/:username/messages" }
+func (api *SendDirectMessageAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.VerifiedUserMiddleware(api.deps)}
+}
+
+func (api *SendDirectMessageAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "SendDirectMessageAPI", "user": user})
+
+	var input messageInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Data == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	recipient, err := models.GetUserByUsername(api.deps.DB(), c.Param("username"))
+	if err != nil {
+		logger.WithError(err).Warn("could not find user w/ username")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	if recipient.ID == user.ID {
+		logger.Warn("cannot send direct message to self")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	db := api.deps.DB().Begin()
+	conversation, err := models.GetConversation(db, user.ID, recipient.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		conversation, err = createConversation(db, user.ID, recipient.ID)
+	}
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not create conversation")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	message, err := models.NewMessage(db, &models.Message{Data: input.Data, Username: user.Username, ChannelID: conversation.ChannelID})
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not create message")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	// conversations are listed by latest activity
+	if err := db.Model(conversation).Update("updated_at", time.Now()).Error; err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not update conversation")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.MarkChannelRead(db, conversation.ChannelID, user.ID, message.ID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not mark conversation read")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for direct message creation")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := utils.PublishMessageCreated(api.deps.PubSub(), message); err != nil {
+		logger.WithError(err).Warn("could not publish message created event")
+	}
+
+	logger.WithField("message", message).Debug("direct message created")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(message))
+}
+
+type GetDirectMessagesAPI struct {
+	deps utils.Deps
+}
+
+func NewGetDirectMessagesAPI(deps utils.Deps) utils.Route {
+	return &GetDirectMessagesAPI{deps}
+}
+
+func (api *GetDirectMessagesAPI) Method() string { return http.MethodGet }
+func (api *GetDirectMessagesAPI) Path() string   { return "/users/:username/messages" }
+func (api *GetDirectMessagesAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+// Handler only ever looks up the conversation between the caller and the
+// other user, so nobody else can reach its history.
+func (api *GetDirectMessagesAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetDirectMessagesAPI", "user": user})
+
+	other, err := models.GetUserByUsername(api.deps.DB(), c.Param("username"))
+	if err != nil {
+		logger.WithError(err).Warn("could not find user w/ username")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	messages := []models.Message{}
+	paginator := utils.NewPaginator(c)
+	conversation, err := models.GetConversation(api.deps.DB(), user.ID, other.ID)
+	if err != nil {
+		return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, messages, &utils.Pagination{Page: paginator.Page, PageSize: paginator.PageSize}))
+	}
+
+	db := api.deps.DB().Session(&gorm.Session{QueryFields: true}).Where("channel_id = ?", conversation.ChannelID)
+	pagination, err := paginator.Find(db.Order("messages.created_at DESC").Order("messages.id DESC"), &messages)
+	if err != nil {
+		logger.WithError(err).Error("could not get direct messages")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	latest, err := models.GetLatestMessages(api.deps.DB(), []uint{conversation.ChannelID})
+	if err != nil {
+		logger.WithError(err).Error("could not get latest direct message")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if message, ok := latest[conversation.ChannelID]; ok {
+		if err := models.MarkChannelRead(api.deps.DB(), conversation.ChannelID, user.ID, message.ID); err != nil {
+			logger.WithError(err).Warn("could not mark conversation read")
+		}
+	}
+
+	logger.WithField("messageCount", len(messages)).Debug("got direct messages")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, messages, pagination))
+}
+
+type GetConversationsAPI struct {
+	deps utils.Deps
+}
+
+func NewGetConversationsAPI(deps utils.Deps) utils.Route {
+	return &GetConversationsAPI{deps}
+}
+
+func (api *GetConversationsAPI) Method() string { return http.MethodGet }
+func (api *GetConversationsAPI) Path() string   { return "/conversations" }
+func (api *GetConversationsAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+func (api *GetConversationsAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetConversationsAPI", "user": user})
+
+	conversations := []models.Conversation{}
+	db := models.GetConversationsForUser(api.deps.DB(), user.ID).Order("updated_at DESC")
+	pagination, err := utils.NewPaginator(c).Find(db, &conversations)
+	if err != nil {
+		logger.WithError(err).Error("could not get conversations")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	channelIDs := []uint{}
+	userIDs := []uint{}
+	for _, conversation := range conversations {
+		channelIDs = append(channelIDs, conversation.ChannelID)
+		userIDs = append(userIDs, conversation.OtherUserID(user.ID))
+	}
+
+	users := []models.User{}
+	if err := api.deps.DB().Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		logger.WithError(err).Error("could not get conversation users")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	usersByID := map[uint]*models.User{}
+	for i := range users {
+		usersByID[users[i].ID] = &users[i]
+	}
+
+	latest, err := models.GetLatestMessages(api.deps.DB(), channelIDs)
+	if err != nil {
+		logger.WithError(err).Error("could not get latest messages")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	unread, err := models.GetUnreadCounts(api.deps.DB(), user, channelIDs)
+	if err != nil {
+		logger.WithError(err).Error("could not get unread counts")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	result := []conversationResponse{}
+	for _, conversation := range conversations {
+		item := conversationResponse{
+			ID:          conversation.ID,
+			ChannelID:   conversation.ChannelID,
+			User:        usersByID[conversation.OtherUserID(user.ID)],
+			UnreadCount: unread[conversation.ChannelID],
+		}
+		if message, ok := latest[conversation.ChannelID]; ok {
+			item.LatestMessage = &message
+		}
+		result = append(result, item)
+	}
+
+	logger.WithField("conversationCount", len(result)).Debug("got conversations")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, result, pagination))
+}
+
+// createConversation re-reads the conversation when a concurrent first message
+// between the same users created it first. Postgres aborts the transaction on
+// the unique violation, so it is rolled back to a savepoint before re-reading.
+func createConversation(db *gorm.DB, userID uint, otherUserID uint) (*models.Conversation, error) {
+	if err := db.SavePoint(conversationSavePoint).Error; err != nil {
+		return nil, err
+	}
+	conversation, err := models.NewConversation(db, userID, otherUserID)
+	if !utils.IsUniqueViolation(err) {
+		return conversation, err
+	}
+	if err := db.RollbackTo(conversationSavePoint).Error; err != nil {
+		return nil, err
+	}
+	return models.GetConversation(db, userID, otherUserID)
+}
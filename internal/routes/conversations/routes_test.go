@@ -0,0 +1,139 @@
+package conversations
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/messages"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectMessageAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := newTestServer(deps)
+	defer server.Close()
+
+	_, aliceToken := testutils.NewUserWithToken(t, deps, "alice")
+	_, bobToken := testutils.NewUserWithToken(t, deps, "bob")
+
+	// without a conversation the history is an empty page
+	status, res := testutils.DoRequest(t, server, http.MethodGet, "/users/bob/messages", aliceToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result.([]interface{}))
+	require.Equal(t, 1, res.Page)
+	require.False(t, res.HasMore)
+
+	i := 0
+	for i < 2 {
+		status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/bob/messages", aliceToken, fmt.Sprintf(`{"data": "hi bob %d"}`, i))
+		require.Equal(t, http.StatusOK, status, res.Error)
+		i++
+	}
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/conversations", bobToken, "")
+	require.Equal(t, http.StatusOK, status)
+	conversations := res.Result.([]interface{})
+	require.Len(t, conversations, 1)
+	conversation := conversations[0].(map[string]interface{})
+	require.Equal(t, "alice", conversation["user"].(map[string]interface{})["username"])
+	require.Equal(t, "hi bob 1", conversation["latest_message"].(map[string]interface{})["data"])
+	require.Equal(t, float64(2), conversation["unread_count"])
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/conversations", aliceToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), res.Result.([]interface{})[0].(map[string]interface{})["unread_count"])
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/users/alice/messages", bobToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 2)
+	require.Equal(t, "hi bob 1", res.Result.([]interface{})[0].(map[string]interface{})["data"]) // newest first
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/conversations", bobToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), res.Result.([]interface{})[0].(map[string]interface{})["unread_count"])
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/alice/messages", bobToken, `{"data": "hi alice"}`)
+	require.Equal(t, http.StatusOK, status)
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/users/bob/messages", aliceToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 3)
+}
+
+func TestCreateConversationRereadsOnRace(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	alice, _ := testutils.NewUserWithToken(t, deps, "alice")
+	bob, _ := testutils.NewUserWithToken(t, deps, "bob")
+
+	// the winner of the race commits the conversation first
+	winner, err := models.NewConversation(deps.DB(), alice.ID, bob.ID)
+	require.NoError(t, err)
+
+	db := deps.DB().Begin()
+	conversation, err := createConversation(db, bob.ID, alice.ID)
+	require.NoError(t, err)
+	require.Equal(t, winner.ID, conversation.ID)
+	require.Equal(t, winner.ChannelID, conversation.ChannelID)
+	_, err = models.NewMessage(db, &models.Message{Data: "hi alice", Username: bob.Username, ChannelID: conversation.ChannelID})
+	require.NoError(t, err)
+	require.NoError(t, db.Commit().Error)
+
+	// the loser's channel was rolled back with the savepoint
+	var channels int64
+	require.NoError(t, deps.DB().Model(&models.Channel{}).Where("direct").Count(&channels).Error)
+	require.Equal(t, int64(1), channels)
+}
+
+func TestDirectMessageAPIsPrivacy(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := newTestServer(deps)
+	defer server.Close()
+
+	_, aliceToken := testutils.NewUserWithToken(t, deps, "alice")
+	_, _ = testutils.NewUserWithToken(t, deps, "bob")
+	_, carolToken := testutils.NewUserWithToken(t, deps, "carol")
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/bob/messages", aliceToken, `{"data": "hi bob"}`)
+	require.Equal(t, http.StatusOK, status)
+	channelID := res.Result.(map[string]interface{})["channel_id"]
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/users/alice/messages", carolToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result.([]interface{}))
+
+	status, _ = testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/channels/%v/messages", channelID), carolToken, "")
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/conversations", carolToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result.([]interface{}))
+	// direct channels have no name, which more than one may share
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/bob/messages", carolToken, `{"data": "hi bob"}`)
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/alice/messages", aliceToken, `{"data": "hi me"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/nobody/messages", aliceToken, `{"data": "hi nobody"}`)
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func newTestServer(deps utils.Deps) *httptest.Server {
+	return httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendDirectMessageAPI(deps),
+		NewGetDirectMessagesAPI(deps),
+		NewGetConversationsAPI(deps),
+		messages.NewGetMessagesAPI(deps),
+	}))
+}
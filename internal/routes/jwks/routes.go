@@ -0,0 +1,58 @@
+package jwks
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// MaxAge bounds how long verifiers cache the key set, so a new key must be
+	// published for at least this long before it starts signing tokens.
+	MaxAge = time.Hour
+)
+
+type jwksResponse struct {
+	Keys []utils.JWK `json:"keys"`
+}
+
+type GetJWKSAPI struct {
+	deps utils.Deps
+}
+
+func NewGetJWKSAPI(deps utils.Deps) utils.Route {
+	return &GetJWKSAPI{deps}
+}
+
+func (api *GetJWKSAPI) Method() string                     { return http.MethodGet }
+func (api *GetJWKSAPI) Path() string                       { return "/.well-known/jwks.json" }
+func (api *GetJWKSAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler serves a plain RFC 7517 key set rather than the usual response
+// envelope, since it is read by standard JWT libraries. The ETag changes
+// whenever the published keys do.
+func (api *GetJWKSAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "GetJWKSAPI")
+
+	body, err := json.Marshal(jwksResponse{Keys: api.deps.KeyRing().JWKs()})
+	if err != nil {
+		logger.WithError(err).Error("could not encode key set")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	header := c.Response().Header()
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(MaxAge.Seconds())))
+	header.Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	logger.Debug("got key set")
+	return c.Blob(http.StatusOK, "application/jwk-set+json", body)
+}
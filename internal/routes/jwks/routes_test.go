@@ -0,0 +1,118 @@
+package jwks
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// keyRingDeps swaps the unit key ring for one with asymmetric keys.
+type keyRingDeps struct {
+	utils.Deps
+	keys *utils.KeyRing
+}
+
+func (deps *keyRingDeps) KeyRing() *utils.KeyRing { return deps.keys }
+
+func TestGetJWKSAPI(t *testing.T) {
+	unitDeps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys, err := utils.NewKeyRing([]utils.KeyConfig{
+		{ID: "ed", Algorithm: "EdDSA", Key: encodePrivateKey(t, edKey)},
+		{ID: "rsa", Algorithm: "RS256", Key: encodePrivateKey(t, rsaKey)},
+		{ID: "secret", Algorithm: "HS256", Key: base64.StdEncoding.EncodeToString([]byte("someSecret"))},
+	}, "ed")
+	require.NoError(t, err)
+	deps := &keyRingDeps{unitDeps, keys}
+
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewGetJWKSAPI(deps)}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "public, max-age=3600", res.Header.Get("Cache-Control"))
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	set := jwksResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&set))
+	require.Len(t, set.Keys, 2) // the HS256 secret is never published
+	require.Equal(t, utils.JWK{KeyType: "OKP", ID: "ed", Algorithm: "EdDSA", Use: "sig", Curve: "Ed25519", X: set.Keys[0].X}, set.Keys[0])
+	require.Equal(t, "RSA", set.Keys[1].KeyType)
+	require.Equal(t, "rsa", set.Keys[1].ID)
+	n, err := base64.RawURLEncoding.DecodeString(set.Keys[1].N)
+	require.NoError(t, err)
+	require.Equal(t, 0, new(big.Int).SetBytes(n).Cmp(rsaKey.N))
+
+	// a third party can verify our tokens with nothing but the published key
+	user, _ := testutils.NewUserWithToken(t, deps, "someUserName")
+	token, err := utils.NewJWT(keys, user, "", time.Minute)
+	require.NoError(t, err)
+	x, err := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	require.NoError(t, err)
+	claims := &utils.Claims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(x), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, utils.DefaultIssuer, claims.Issuer)
+	require.Equal(t, utils.DefaultAudience, claims.Audience)
+	require.Equal(t, "1", claims.Subject)
+	require.NotZero(t, claims.IssuedAt)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/.well-known/jwks.json", nil)
+	require.NoError(t, err)
+	r.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+}
+
+func TestValidateJWTAudience(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+	require.NoError(t, err)
+
+	deps.KeyRing().Audience = "someOtherService"
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+	require.Error(t, err)
+
+	token, err = utils.NewJWT(deps.KeyRing(), user, "", time.Minute)
+	require.NoError(t, err)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+	require.NoError(t, err)
+}
+
+func encodePrivateKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
@@ -0,0 +1,127 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+	heartbeatPeriod   = 15 * time.Second
+	maxReplayMessages = 500
+)
+
+type MessageEventsAPI struct {
+	deps            utils.Deps
+	authCheckPeriod time.Duration
+}
+
+func NewMessageEventsAPI(deps utils.Deps) utils.Route {
+	return &MessageEventsAPI{deps, middlewares.AuthCheckPeriod}
+}
+
+func (api *MessageEventsAPI) Method() string { return http.MethodGet }
+func (api *MessageEventsAPI) Path() string   { return "/messages/events" }
+func (api *MessageEventsAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+func (api *MessageEventsAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "MessageEventsAPI", "user": user})
+
+	var lastEventID uint64
+	if s := c.Request().Header.Get(lastEventIDHeader); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			logger.WithError(err).Warn(utils.BadRequestMsg)
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+		}
+		lastEventID = id
+	}
+
+	// subscribe before replaying so nothing committed in between is lost
+	client := api.deps.Hub().Subscribe()
+	defer client.Close()
+
+	replay := []models.Message{}
+	if lastEventID > 0 {
+		readable := models.ReadableChannelIDs(api.deps.DB(), user.ID)
+		if err := api.deps.DB().Where("id > ? AND channel_id IN (?)", lastEventID, readable).Order("id").Limit(maxReplayMessages).Find(&replay).Error; err != nil {
+			logger.WithError(err).Error("could not get messages to replay")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	logger.WithField("replayCount", len(replay)).Debug("event stream connected")
+
+	for i := range replay {
+		if err := writeMessageEvent(res, &replay[i]); err != nil {
+			logger.WithError(err).Warn("could not write replayed message event")
+			return nil
+		}
+		lastEventID = uint64(replay[i].ID)
+	}
+
+	lapsed := middlewares.WatchAuth(api.deps, c, api.authCheckPeriod)
+	canRead := newChannelFilter(api.deps.DB(), api.deps.Hub(), user, logger)
+	ticker := time.NewTicker(heartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			logger.Debug("event stream disconnected")
+			return nil
+		case <-lapsed:
+			// clients reconnecting with the same credentials are rejected
+			logger.Debug("event stream closed, credentials lapsed")
+			return nil
+		case message, ok := <-client.Messages():
+			if !ok {
+				logger.Warn("event stream dropped by hub")
+				return nil
+			}
+			if uint64(message.ID) <= lastEventID || !canRead(message) {
+				continue
+			}
+			if err := writeMessageEvent(res, message); err != nil {
+				logger.WithError(err).Warn("could not write message event")
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeMessageEvent(res *echo.Response, message *models.Message) error {
+	data, err := json.Marshal(utils.NewSuccessResponse(message))
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "id: %d\nevent: message\ndata: %s\n\n", message.ID, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,205 @@
+package messages
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type messageEvent struct {
+	id   string
+	data utils.Response
+}
+
+func TestMessageEventsAPI(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewMessageEventsAPI(deps)}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+
+	res, events := openEvents(t, server, token, "")
+	defer res.Body.Close()
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	waitForClients(t, deps, 1)
+
+	message, err := models.NewMessage(deps.DB(), &models.Message{Data: "some live message", Username: user.Username, ChannelID: channel.ID})
+	require.NoError(t, err)
+	deps.Hub().Broadcast(message)
+
+	event := <-events
+	require.Equal(t, fmt.Sprintf("%d", message.ID), event.id)
+	require.Equal(t, "some live message", event.data.Result.(map[string]interface{})["data"])
+}
+
+func TestMessageEventsAPIReplay(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewMessageEventsAPI(deps)}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+
+	messages := []*models.Message{}
+	i := 0
+	for i < 3 {
+		message, err := models.NewMessage(deps.DB(), &models.Message{Data: fmt.Sprintf("some message data %d", i), Username: user.Username, ChannelID: channel.ID})
+		require.NoError(t, err)
+		messages = append(messages, message)
+		_, err = models.NewMessage(deps.DB(), &models.Message{Data: "some hidden message", ChannelID: private.ID})
+		require.NoError(t, err)
+		i++
+	}
+
+	res, events := openEvents(t, server, token, fmt.Sprintf("%d", messages[0].ID))
+	defer res.Body.Close()
+
+	for _, message := range messages[1:] {
+		event := <-events
+		require.Equal(t, fmt.Sprintf("%d", message.ID), event.id)
+	}
+
+	// already replayed messages must not be delivered twice
+	waitForClients(t, deps, 1)
+	deps.Hub().Broadcast(messages[2])
+	live, err := models.NewMessage(deps.DB(), &models.Message{Data: "some live message", Username: user.Username, ChannelID: channel.ID})
+	require.NoError(t, err)
+	deps.Hub().Broadcast(live)
+
+	event := <-events
+	require.Equal(t, fmt.Sprintf("%d", live.ID), event.id)
+}
+
+func TestMessageEventsAPIInvalidLastEventID(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewMessageEventsAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	res, _ := openEvents(t, server, token, "notAnID")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestMessageEventsAPIClosesWhenAuthLapses(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	api := NewMessageEventsAPI(deps).(*MessageEventsAPI)
+	api.authCheckPeriod = 10 * time.Millisecond
+	server := httptest.NewServer(utils.NewServer([]utils.Route{api}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	res, events := openEvents(t, server, token, "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	waitForClients(t, deps, 1)
+
+	// deleting the user invalidates their tokens
+	require.NoError(t, deps.DB().Delete(user).Error)
+	select {
+	case _, ok := <-events:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "event stream outlived its token")
+	}
+	waitForClients(t, deps, 0)
+}
+
+func TestMessageEventsAPIClosesWhenAPIKeyIsDeleted(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	api := NewMessageEventsAPI(deps).(*MessageEventsAPI)
+	api.authCheckPeriod = 10 * time.Millisecond
+	server := httptest.NewServer(utils.NewServer([]utils.Route{api}))
+	defer server.Close()
+
+	user, _ := testutils.NewUserWithToken(t, deps, "someUserName")
+	key, prefix, keyHash, err := utils.NewAPIKey()
+	require.NoError(t, err)
+	apiKey, err := models.NewAPIKey(deps.DB(), &models.APIKey{UserID: user.ID, Name: "someKey", Prefix: prefix, KeyHash: keyHash, Scopes: models.ScopeList{models.ScopeMessagesRead}})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/messages/events", nil)
+	require.NoError(t, err)
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+key)
+	res, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	waitForClients(t, deps, 1)
+
+	closed := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(res.Body)
+		close(closed)
+	}()
+	_, err = models.DeleteAPIKey(deps.DB(), user.ID, apiKey.ID)
+	require.NoError(t, err)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "event stream outlived its api key")
+	}
+	waitForClients(t, deps, 0)
+}
+
+func openEvents(t *testing.T, server *httptest.Server, token, lastEventID string) (*http.Response, <-chan messageEvent) {
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/messages/events", nil)
+	require.NoError(t, err)
+	r.Header.Set(middlewares.JwtRequestHeader, token)
+	if lastEventID != "" {
+		r.Header.Set(lastEventIDHeader, lastEventID)
+	}
+	res, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+
+	events := make(chan messageEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(res.Body)
+		event := messageEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data)
+			case line == "" && event.id != "":
+				events <- event
+				event = messageEvent{}
+			}
+		}
+	}()
+	return res, events
+}
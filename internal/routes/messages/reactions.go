@@ -0,0 +1,101 @@
+package messages
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	maxEmojiLength = 32
+)
+
+type AddReactionAPI struct {
+	deps utils.Deps
+}
+
+func NewAddReactionAPI(deps utils.Deps) utils.Route {
+	return &AddReactionAPI{deps}
+}
+
+func (api *AddReactionAPI) Method() string { return http.MethodPut }
+func (api *AddReactionAPI) Path() string   { return "/messages/:id/reactions/:emoji" }
+func (api *AddReactionAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *AddReactionAPI) Handler(c echo.Context) error {
+	return handleReaction(c, api.deps, "AddReactionAPI", models.AddReaction)
+}
+
+type RemoveReactionAPI struct {
+	deps utils.Deps
+}
+
+func NewRemoveReactionAPI(deps utils.Deps) utils.Route {
+	return &RemoveReactionAPI{deps}
+}
+
+func (api *RemoveReactionAPI) Method() string { return http.MethodDelete }
+func (api *RemoveReactionAPI) Path() string   { return "/messages/:id/reactions/:emoji" }
+func (api *RemoveReactionAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *RemoveReactionAPI) Handler(c echo.Context) error {
+	return handleReaction(c, api.deps, "RemoveReactionAPI", models.RemoveReaction)
+}
+
+// handleReaction applies the change and responds with the message's updated
+// reaction summaries.
+func handleReaction(c echo.Context, deps utils.Deps, name string, apply func(*gorm.DB, *models.Message, uint, string) error) error {
+	user := middlewares.RequireUser(c)
+	message := middlewares.RequireMessage(c)
+	logger := deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": name, "user": user, "message": message.ID})
+
+	emoji, err := url.PathUnescape(c.Param("emoji"))
+	if err != nil || !validEmoji(emoji) {
+		logger.WithError(err).Warn("invalid emoji")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	db := deps.DB().Begin()
+	if err := apply(db, message, user.ID, emoji); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not update reaction")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for reaction")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	summaries, err := models.GetReactionSummaries(deps.DB(), user.ID, []uint{message.ID})
+	if err != nil {
+		logger.WithError(err).Error("could not get reactions")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("emoji", emoji).Debug("reaction updated")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(reactionsOrEmpty(summaries[message.ID])))
+}
+
+func validEmoji(emoji string) bool {
+	return emoji != "" && utf8.RuneCountInString(emoji) <= maxEmojiLength && !strings.ContainsAny(emoji, " \t\r\n/")
+}
+
+func reactionsOrEmpty(summaries []models.ReactionSummary) []models.ReactionSummary {
+	if summaries == nil {
+		return []models.ReactionSummary{}
+	}
+	return summaries
+}
@@ -0,0 +1,108 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReactionAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewGetMessagesAPI(deps),
+		NewAddReactionAPI(deps),
+		NewRemoveReactionAPI(deps),
+	}))
+	defer server.Close()
+
+	_, aliceToken := testutils.NewUserWithToken(t, deps, "alice")
+	_, bobToken := testutils.NewUserWithToken(t, deps, "bob")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	message, err := models.NewMessage(deps.DB(), &models.Message{Data: "some message", Username: "alice", ChannelID: channel.ID})
+	require.NoError(t, err)
+
+	thumbsUp := reactionPath(message, "👍")
+	party := reactionPath(message, "🎉")
+
+	// adding the same reaction twice is a no-op
+	i := 0
+	for i < 2 {
+		status, res := testutils.DoRequest(t, server, http.MethodPut, thumbsUp, aliceToken, "")
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, res.Result.([]interface{}), 1)
+		i++
+	}
+	status, _ := testutils.DoRequest(t, server, http.MethodPut, thumbsUp, bobToken, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPut, party, bobToken, "")
+	require.Equal(t, http.StatusOK, status)
+
+	updated, err := models.GetMessageByID(deps.DB(), message.ID)
+	require.NoError(t, err)
+	require.True(t, updated.UpdatedAt.After(message.UpdatedAt))
+
+	status, res := testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/channels/%d/messages", channel.ID), aliceToken, "")
+	require.Equal(t, http.StatusOK, status)
+	reactions := res.Result.([]interface{})[0].(map[string]interface{})["reactions"].([]interface{})
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"emoji": "🎉", "count": float64(1), "reacted_by_me": false},
+		map[string]interface{}{"emoji": "👍", "count": float64(2), "reacted_by_me": true},
+	}, reactions)
+
+	i = 0
+	for i < 2 {
+		status, res = testutils.DoRequest(t, server, http.MethodDelete, thumbsUp, aliceToken, "")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, []interface{}{
+			map[string]interface{}{"emoji": "🎉", "count": float64(1), "reacted_by_me": false},
+			map[string]interface{}{"emoji": "👍", "count": float64(1), "reacted_by_me": false},
+		}, res.Result)
+		i++
+	}
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPut, fmt.Sprintf("/messages/%d/reactions/%s", message.ID, url.PathEscape("not an emoji")), aliceToken, "")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestAddReactionConcurrently(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	alice, _ := testutils.NewUserWithToken(t, deps, "alice")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	message, err := models.NewMessage(deps.DB(), &models.Message{Data: "some message", Username: "alice", ChannelID: channel.ID})
+	require.NoError(t, err)
+
+	const requests = 5
+	errs := make(chan error, requests)
+	i := 0
+	for i < requests {
+		go func() { errs <- models.AddReaction(deps.DB(), message, alice.ID, "👍") }()
+		i++
+	}
+	i = 0
+	for i < requests {
+		require.NoError(t, <-errs)
+		i++
+	}
+	var count int64
+	require.NoError(t, deps.DB().Model(&models.Reaction{}).Where("message_id = ?", message.ID).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+}
+
+func reactionPath(message *models.Message, emoji string) string {
+	return fmt.Sprintf("/messages/%d/reactions/%s", message.ID, url.PathEscape(emoji))
+}
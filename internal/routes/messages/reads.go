@@ -0,0 +1,142 @@
+package messages
+
+import (
+	"net/http"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+type readInput struct {
+	ChannelID uint  `json:"channel_id"`
+	MessageID *uint `json:"message_id"` // defaults to the channel's latest message
+}
+
+type unreadCount struct {
+	ChannelID   uint  `json:"channel_id"`
+	UnreadCount int64 `json:"unread_count"`
+}
+
+type unreadResponse struct {
+	Total    int64         `json:"total"`
+	Channels []unreadCount `json:"channels"`
+}
+
+type MarkReadAPI struct {
+	deps utils.Deps
+}
+
+func NewMarkReadAPI(deps utils.Deps) utils.Route {
+	return &MarkReadAPI{deps}
+}
+
+func (api *MarkReadAPI) Method() string { return http.MethodPost }
+func (api *MarkReadAPI) Path() string   { return "/messages/read" }
+func (api *MarkReadAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite)}
+}
+
+func (api *MarkReadAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "MarkReadAPI", "user": user})
+
+	var input readInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.ChannelID == 0 {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	ok, err := models.CanReadChannelByID(api.deps.DB(), input.ChannelID, user.ID)
+	if err != nil {
+		logger.WithError(err).Warn("could not find channel")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+	if !ok {
+		logger.Warn("user is not a member of private channel")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	var messageID uint
+	if input.MessageID != nil {
+		message, err := models.GetMessageByID(api.deps.DB(), *input.MessageID)
+		if err != nil || message.ChannelID != input.ChannelID {
+			logger.WithError(err).Warn("invalid message")
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+		}
+		messageID = message.ID
+	} else {
+		latest, err := models.GetLatestMessages(api.deps.DB(), []uint{input.ChannelID})
+		if err != nil {
+			logger.WithError(err).Error("could not get latest message")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+		messageID = latest[input.ChannelID].ID
+	}
+
+	if err := models.MarkChannelRead(api.deps.DB(), input.ChannelID, user.ID, messageID); err != nil {
+		logger.WithError(err).Error("could not mark channel read")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	positions, err := models.GetReadPositions(api.deps.DB(), user.ID, []uint{input.ChannelID})
+	if err != nil {
+		logger.WithError(err).Error("could not get read position")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("channel", input.ChannelID).Debug("channel marked read")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(models.ReadPosition{
+		ChannelID:         input.ChannelID,
+		UserID:            user.ID,
+		LastReadMessageID: positions[input.ChannelID],
+	}))
+}
+
+type GetUnreadAPI struct {
+	deps utils.Deps
+}
+
+func NewGetUnreadAPI(deps utils.Deps) utils.Route {
+	return &GetUnreadAPI{deps}
+}
+
+func (api *GetUnreadAPI) Method() string { return http.MethodGet }
+func (api *GetUnreadAPI) Path() string   { return "/messages/unread" }
+func (api *GetUnreadAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+func (api *GetUnreadAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetUnreadAPI", "user": user})
+
+	channelIDs := []uint{}
+	if err := models.ReadableChannelIDs(api.deps.DB(), user.ID).Pluck("id", &channelIDs).Error; err != nil {
+		logger.WithError(err).Error("could not get readable channels")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	counts, err := models.GetUnreadCounts(api.deps.DB(), user, channelIDs)
+	if err != nil {
+		logger.WithError(err).Error("could not get unread counts")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	result := unreadResponse{Channels: []unreadCount{}}
+	for _, channelID := range channelIDs {
+		if count := counts[channelID]; count > 0 {
+			result.Total += count
+			result.Channels = append(result.Channels, unreadCount{ChannelID: channelID, UnreadCount: count})
+		}
+	}
+
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(result))
+}
@@ -0,0 +1,125 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewGetMessagesAPI(deps),
+		NewMarkReadAPI(deps),
+		NewGetUnreadAPI(deps),
+	}))
+	defer server.Close()
+
+	_, aliceToken := testutils.NewUserWithToken(t, deps, "alice")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+
+	messages := []*models.Message{}
+	i := 0
+	for i < 3 {
+		message, err := models.NewMessage(deps.DB(), &models.Message{Data: fmt.Sprintf("some message data %d", i), Username: "bob", ChannelID: channel.ID})
+		require.NoError(t, err)
+		messages = append(messages, message)
+		i++
+	}
+	_, err = models.NewMessage(deps.DB(), &models.Message{Data: "some message of my own", Username: "alice", ChannelID: channel.ID})
+	require.NoError(t, err)
+
+	requireUnread(t, server, aliceToken, 3)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/messages/read", aliceToken, fmt.Sprintf(`{"channel_id": %d, "message_id": %d}`, channel.ID, messages[1].ID))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(messages[1].ID), res.Result.(map[string]interface{})["last_read_message_id"])
+	requireUnread(t, server, aliceToken, 1)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/channels/%d/messages", channel.ID), aliceToken, "")
+	require.Equal(t, http.StatusOK, status)
+	unread := []interface{}{}
+	for _, message := range res.Result.([]interface{}) {
+		unread = append(unread, message.(map[string]interface{})["unread"])
+	}
+	require.Equal(t, []interface{}{false, true, false, false}, unread) // newest first
+
+	// read positions never move backwards
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/messages/read", aliceToken, fmt.Sprintf(`{"channel_id": %d, "message_id": %d}`, channel.ID, messages[0].ID))
+	require.Equal(t, http.StatusOK, status)
+	requireUnread(t, server, aliceToken, 1)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/messages/read", aliceToken, fmt.Sprintf(`{"channel_id": %d}`, channel.ID))
+	require.Equal(t, http.StatusOK, status)
+	requireUnread(t, server, aliceToken, 0)
+}
+
+func TestReadAPIsPrivateChannel(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewMarkReadAPI(deps),
+		NewGetUnreadAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	general, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+	hidden, err := models.NewMessage(deps.DB(), &models.Message{Data: "some hidden message", ChannelID: private.ID})
+	require.NoError(t, err)
+
+	requireUnread(t, server, token, 0)
+
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/messages/read", token, fmt.Sprintf(`{"channel_id": %d}`, private.ID))
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/messages/read", token, fmt.Sprintf(`{"channel_id": %d, "message_id": %d}`, general.ID, hidden.ID))
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func requireUnread(t *testing.T, server *httptest.Server, token string, total int) {
+	status, res := testutils.DoRequest(t, server, http.MethodGet, "/messages/unread", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(total), res.Result.(map[string]interface{})["total"])
+}
+
+func TestMarkChannelReadConcurrently(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	alice, _ := testutils.NewUserWithToken(t, deps, "alice")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+
+	// receipts for the first read position arrive together and out of order
+	const receipts = 5
+	errs := make(chan error, receipts)
+	i := 0
+	for i < receipts {
+		messageID := uint(receipts - i)
+		go func() { errs <- models.MarkChannelRead(deps.DB(), channel.ID, alice.ID, messageID) }()
+		i++
+	}
+	i = 0
+	for i < receipts {
+		require.NoError(t, <-errs)
+		i++
+	}
+	positions, err := models.GetReadPositions(deps.DB(), alice.ID, []uint{channel.ID})
+	require.NoError(t, err)
+	require.Equal(t, uint(receipts), positions[channel.ID])
+}
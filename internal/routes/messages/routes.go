@@ -16,7 +16,8 @@ type SendMessageAPI struct {
 }
 
 type messageInput struct {
-	Data string `json:"data"`
+	Data     string `json:"data"`
+	ParentID *uint  `json:"parent_id"`
 }
 
 func NewSendMessageAPI(deps utils.Deps) utils.Route {
@@ -24,14 +25,15 @@ func NewSendMessageAPI(deps utils.Deps) utils.Route {
 }
 
 func (api *SendMessageAPI) Method() string { return http.MethodPost }
-func (api *SendMessageAPI) Path() string   { return "/messages" }
+func (api *SendMessageAPI) Path() string   { return "/channels/:id/messages" }
 func (api *SendMessageAPI) Middlewares() []echo.MiddlewareFunc {
-	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.VerifiedUserMiddleware(api.deps), middlewares.ChannelAccessMiddleware(api.deps)}
 }
 
 func (api *SendMessageAPI) Handler(c echo.Context) error {
 	user := middlewares.RequireUser(c)
-	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "SendMessageAPI", "user": user})
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "SendMessageAPI", "user": user, "channel": channel.ID})
 
 	var input messageInput
 	if err := c.Bind(&input); err != nil {
@@ -44,23 +46,85 @@ func (api *SendMessageAPI) Handler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
 	}
 
+	if input.ParentID != nil {
+		parent, err := models.GetMessageByID(api.deps.DB(), *input.ParentID)
+		if err != nil || parent.ChannelID != channel.ID {
+			logger.WithError(err).Warn("invalid parent message")
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+		}
+		if parent.ParentID != nil {
+			// replies to replies are flattened into the root thread
+			input.ParentID = parent.ParentID
+		}
+	}
+
 	db := api.deps.DB().Begin()
-	message, err := models.NewMessage(db, &models.Message{Data: input.Data, Username: user.Username})
+	message, err := models.NewMessage(db, &models.Message{Data: input.Data, Username: user.Username, ChannelID: channel.ID, ParentID: input.ParentID})
 	if err != nil {
 		db.Rollback()
 		logger.WithError(err).Error("could not create message")
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
+	if message.ParentID != nil {
+		if err := models.AddReply(db, message); err != nil {
+			db.Rollback()
+			logger.WithError(err).Error("could not update parent message")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+	}
+
 	if err := db.Commit().Error; err != nil {
 		logger.WithError(err).Error("could not commit transaction for user creation")
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
+	if err := utils.PublishMessageCreated(api.deps.PubSub(), message); err != nil {
+		// the message is already committed, so live listeners just miss it
+		logger.WithError(err).Warn("could not publish message created event")
+	}
+
 	logger.WithField("message", message).Debug("message created")
 	return c.JSON(http.StatusOK, utils.NewSuccessResponse(message))
 }
 
+type messageResponse struct {
+	models.Message
+	Reactions []models.ReactionSummary `json:"reactions"`
+	Unread    bool                     `json:"unread"`
+}
+
+// newMessageResponses decorates a page of messages with everything computed
+// per user, using one query per decoration rather than one per message.
+func newMessageResponses(db *gorm.DB, user *models.User, messages []models.Message) ([]messageResponse, error) {
+	ids := []uint{}
+	channelIDs := []uint{}
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		channelIDs = append(channelIDs, message.ChannelID)
+	}
+
+	reactions, err := models.GetReactionSummaries(db, user.ID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := models.GetReadPositions(db, user.ID, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []messageResponse{}
+	for _, message := range messages {
+		result = append(result, messageResponse{
+			Message:   message,
+			Reactions: reactionsOrEmpty(reactions[message.ID]),
+			Unread:    message.ID > positions[message.ChannelID] && message.Username != user.Username,
+		})
+	}
+	return result, nil
+}
+
 type GetMessagesAPI struct {
 	deps utils.Deps
 }
@@ -70,18 +134,290 @@ func NewGetMessagesAPI(deps utils.Deps) utils.Route {
 }
 
 func (api *GetMessagesAPI) Method() string { return http.MethodGet }
-func (api *GetMessagesAPI) Path() string   { return "/messages" }
+func (api *GetMessagesAPI) Path() string   { return "/channels/:id/messages" }
 func (api *GetMessagesAPI) Middlewares() []echo.MiddlewareFunc {
-	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead), middlewares.ChannelAccessMiddleware(api.deps)}
 }
 
 func (api *GetMessagesAPI) Handler(c echo.Context) error {
 	user := middlewares.RequireUser(c)
-	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetMessagesAPI", "user": user})
+	channel := middlewares.RequireChannel(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetMessagesAPI", "user": user, "channel": channel.ID})
+
+	query, err := utils.ParseCursorQuery(c)
+	if err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
 
 	messages := []models.Message{}
-	api.deps.DB().Session(&gorm.Session{QueryFields: true}).Scopes(utils.NewPaginator(c)).Find(&messages)
+	var pagination *utils.Pagination
+	db := api.deps.DB().Session(&gorm.Session{QueryFields: true}).Where("channel_id = ? AND parent_id IS NULL", channel.ID)
+	if query == nil {
+		pagination, err = findOffsetPage(c, db, &messages)
+	} else {
+		messages, pagination, err = findCursorPage(db, query)
+	}
+	if err != nil {
+		logger.WithError(err).Error("could not get messages")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	result, err := newMessageResponses(api.deps.DB(), user, messages)
+	if err != nil {
+		logger.WithError(err).Error("could not decorate messages")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
 
 	logger.WithField("messageCount", len(messages)).Debug("got messages")
-	return c.JSON(http.StatusOK, utils.NewSuccessResponse(messages))
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, result, pagination))
+}
+
+// findOffsetPage loads a legacy offset page of the messages matched by db,
+// with cursors for clients switching to cursor paging.
+func findOffsetPage(c echo.Context, db *gorm.DB, messages *[]models.Message) (*utils.Pagination, error) {
+	pagination, err := utils.NewPaginator(c).Find(db.Order("messages.created_at DESC").Order("messages.id DESC"), messages)
+	if err != nil || len(*messages) == 0 {
+		return pagination, err
+	}
+	first, last := (*messages)[0], (*messages)[len(*messages)-1]
+	pagination.PrevCursor = utils.Cursor{CreatedAt: first.CreatedAt, ID: first.ID}.Encode()
+	if pagination.HasMore {
+		pagination.NextCursor = utils.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	return pagination, nil
+}
+
+// findCursorPage loads one keyset page of the messages matched by db and
+// describes it, counting every matched message as the total.
+func findCursorPage(db *gorm.DB, query *utils.CursorQuery) ([]models.Message, *utils.Pagination, error) {
+	pagination := &utils.Pagination{PageSize: query.Limit}
+	if err := db.Session(&gorm.Session{}).Model(&models.Message{}).Count(&pagination.Total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	messages := []models.Message{}
+	if err := db.Session(&gorm.Session{}).Scopes(query.Scope("messages")).Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+
+	pagination.HasMore = len(messages) > query.Limit
+	messages, older, newer := cursorPage(query, messages)
+	if older != nil {
+		pagination.NextCursor = older.Encode()
+	}
+	if newer != nil {
+		pagination.PrevCursor = newer.Encode()
+	}
+	return messages, pagination, nil
+}
+
+// cursorPage trims the extra row fetched by CursorQuery.Scope, restores newest
+// first order and works out the cursors for the neighbouring pages. A newer
+// cursor is always returned so clients can poll for new messages with it.
+func cursorPage(query *utils.CursorQuery, messages []models.Message) ([]models.Message, *utils.Cursor, *utils.Cursor) {
+	hasMore := len(messages) > query.Limit
+	if hasMore {
+		messages = messages[:query.Limit]
+	}
+
+	if query.After != nil {
+		i, j := 0, len(messages)-1
+		for i < j {
+			messages[i], messages[j] = messages[j], messages[i]
+			i++
+			j--
+		}
+	}
+
+	if len(messages) == 0 {
+		return messages, nil, query.After
+	}
+
+	var older *utils.Cursor
+	if hasMore || query.After != nil {
+		last := messages[len(messages)-1]
+		older = &utils.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	first := messages[0]
+	return messages, older, &utils.Cursor{CreatedAt: first.CreatedAt, ID: first.ID}
+}
+
+type GetRepliesAPI struct {
+	deps utils.Deps
+}
+
+func NewGetRepliesAPI(deps utils.Deps) utils.Route {
+	return &GetRepliesAPI{deps}
+}
+
+func (api *GetRepliesAPI) Method() string { return http.MethodGet }
+func (api *GetRepliesAPI) Path() string   { return "/messages/:id/replies" }
+func (api *GetRepliesAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *GetRepliesAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	parent := middlewares.RequireMessage(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetRepliesAPI", "user": user, "message": parent.ID})
+
+	replies := []models.Message{}
+	pagination, err := utils.NewPaginator(c).Find(api.deps.DB().Where("parent_id = ?", parent.ID).Order("id"), &replies)
+	if err != nil {
+		logger.WithError(err).Error("could not get replies")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	result, err := newMessageResponses(api.deps.DB(), user, replies)
+	if err != nil {
+		logger.WithError(err).Error("could not decorate replies")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("replyCount", len(replies)).Debug("got replies")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, result, pagination))
+}
+
+type EditMessageAPI struct {
+	deps utils.Deps
+}
+
+type editMessageInput struct {
+	Data string `json:"data"`
+}
+
+func NewEditMessageAPI(deps utils.Deps) utils.Route {
+	return &EditMessageAPI{deps}
+}
+
+func (api *EditMessageAPI) Method() string { return http.MethodPatch }
+func (api *EditMessageAPI) Path() string   { return "/messages/:id" }
+func (api *EditMessageAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *EditMessageAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	message := middlewares.RequireMessage(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "EditMessageAPI", "user": user, "message": message.ID})
+
+	if message.Username != user.Username {
+		logger.Warn("only the author may edit a message")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	var input editMessageInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Data == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Data == message.Data {
+		return c.JSON(http.StatusOK, utils.NewSuccessResponse(message))
+	}
+
+	db := api.deps.DB().Begin()
+	if err := models.EditMessage(db, message, input.Data); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not edit message")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for message edit")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("message edited")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(message))
+}
+
+// DeleteMessageAPI deletes a top-level message together with its replies,
+// which could not be reached without it.
+type DeleteMessageAPI struct {
+	deps utils.Deps
+}
+
+func NewDeleteMessageAPI(deps utils.Deps) utils.Route {
+	return &DeleteMessageAPI{deps}
+}
+
+func (api *DeleteMessageAPI) Method() string { return http.MethodDelete }
+func (api *DeleteMessageAPI) Path() string   { return "/messages/:id" }
+func (api *DeleteMessageAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesWrite), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *DeleteMessageAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	message := middlewares.RequireMessage(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "DeleteMessageAPI", "user": user, "message": message.ID})
+
+	if message.Username != user.Username {
+		logger.Warn("only the author may delete a message")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	db := api.deps.DB().Begin()
+	if err := db.Delete(message).Error; err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not delete message")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if message.ParentID != nil {
+		if err := models.RemoveReply(db, message); err != nil {
+			db.Rollback()
+			logger.WithError(err).Error("could not update parent message")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+	} else if err := models.DeleteReplies(db, message); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not delete replies")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for message deletion")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("message deleted")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(message))
+}
+
+type GetMessageRevisionsAPI struct {
+	deps utils.Deps
+}
+
+func NewGetMessageRevisionsAPI(deps utils.Deps) utils.Route {
+	return &GetMessageRevisionsAPI{deps}
+}
+
+func (api *GetMessageRevisionsAPI) Method() string { return http.MethodGet }
+func (api *GetMessageRevisionsAPI) Path() string   { return "/messages/:id/revisions" }
+func (api *GetMessageRevisionsAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead), middlewares.MessageAccessMiddleware(api.deps)}
+}
+
+func (api *GetMessageRevisionsAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	message := middlewares.RequireMessage(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetMessageRevisionsAPI", "user": user, "message": message.ID})
+
+	revisions := []models.MessageRevision{}
+	pagination, err := utils.NewPaginator(c).Find(api.deps.DB().Where("message_id = ?", message.ID).Order("id"), &revisions)
+	if err != nil {
+		logger.WithError(err).Error("could not get message revisions")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("revisionCount", len(revisions)).Debug("got message revisions")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, revisions, pagination))
 }
@@ -10,6 +10,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Krajiyah/nimble-interview-backend/internal/models"
 	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
@@ -32,6 +33,8 @@ func TestMessageAPIs(t *testing.T) {
 		PasswordHash: "someHashOfPassword",
 	})
 	require.NoError(t, err)
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
 
 	const (
 		numMessages = 100
@@ -41,12 +44,13 @@ func TestMessageAPIs(t *testing.T) {
 	i := 0
 	for i < numMessages {
 		body := createMessageInput(fmt.Sprintf("some message data %d", i))
-		r, err := http.NewRequest(http.MethodPost, "/messages", body)
+		r, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/channels/%d/messages", channel.ID), body)
 		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		require.NoError(t, err)
 		w := httptest.NewRecorder()
 		c := echo.New().NewContext(r, w)
 		c.Set(middlewares.UserContextKey, user)
+		c.Set(middlewares.ChannelContextKey, channel)
 		require.NoError(t, sendMessageAPI.Handler(c))
 		require.Equal(t, http.StatusOK, w.Result().StatusCode)
 		i++
@@ -55,11 +59,12 @@ func TestMessageAPIs(t *testing.T) {
 	page := 1
 	messages := mapset.NewSet()
 	for page <= numMessages/pageSize {
-		r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/messages?page=%d&pageSize=%d", page, pageSize), nil)
+		r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/channels/%d/messages?page=%d&pageSize=%d", channel.ID, page, pageSize), nil)
 		require.NoError(t, err)
 		w := httptest.NewRecorder()
 		c := echo.New().NewContext(r, w)
 		c.Set(middlewares.UserContextKey, user)
+		c.Set(middlewares.ChannelContextKey, channel)
 		require.NoError(t, getMessagesAPI.Handler(c))
 		require.Equal(t, http.StatusOK, w.Result().StatusCode)
 		body, err := ioutil.ReadAll(w.Result().Body)
@@ -77,6 +82,353 @@ func TestMessageAPIs(t *testing.T) {
 	require.Equal(t, numMessages, messages.Cardinality())
 }
 
+func TestGetMessagesAPICursors(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewGetMessagesAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	const numMessages = 25
+	createdAt := time.Now()
+	i := 0
+	for i < numMessages {
+		// every other message shares its timestamp, so ties must be broken by id
+		if i%2 == 0 {
+			createdAt = createdAt.Add(time.Second)
+		}
+		message := &models.Message{Data: fmt.Sprintf("some message data %d", i), Username: "someUserName", ChannelID: channel.ID}
+		message.CreatedAt = createdAt
+		_, err := models.NewMessage(deps.DB(), message)
+		require.NoError(t, err)
+		i++
+	}
+
+	status, res := testutils.DoRequest(t, server, http.MethodGet, path, token, "")
+	require.Equal(t, http.StatusOK, status)
+	newest := res.PrevCursor
+	require.Equal(t, int64(numMessages), res.Total)
+	require.True(t, res.HasMore)
+
+	datas := []interface{}{}
+	pageSizes := []int{}
+	for {
+		for _, message := range res.Result.([]interface{}) {
+			datas = append(datas, message.(map[string]interface{})["data"])
+		}
+		pageSizes = append(pageSizes, len(res.Result.([]interface{})))
+		if res.NextCursor == "" {
+			break
+		}
+		status, res = testutils.DoRequest(t, server, http.MethodGet, path+"?before="+res.NextCursor, token, "")
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	require.Equal(t, []int{10, 10, 5}, pageSizes)
+	require.Len(t, datas, numMessages)
+	for j, data := range datas {
+		require.Equal(t, fmt.Sprintf("some message data %d", numMessages-1-j), data)
+	}
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"?after="+newest, token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result.([]interface{}))
+	require.Equal(t, newest, res.PrevCursor)
+
+	message := &models.Message{Data: "some new message", Username: "someUserName", ChannelID: channel.ID}
+	message.CreatedAt = createdAt.Add(time.Second)
+	_, err = models.NewMessage(deps.DB(), message)
+	require.NoError(t, err)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"?after="+newest, token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, res.Result.([]interface{}), 1)
+	require.Equal(t, "some new message", res.Result.([]interface{})[0].(map[string]interface{})["data"])
+	require.NotEqual(t, newest, res.PrevCursor)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodGet, path+"?before=notACursor", token, "")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// clients that only send page_size keep getting offset pages
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path+"?page_size=5", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, 1, res.Page)
+	require.Equal(t, 5, res.PageSize)
+	require.Len(t, res.Result.([]interface{}), 5)
+	require.Equal(t, "some new message", res.Result.([]interface{})[0].(map[string]interface{})["data"])
+}
+
+func TestSendMessageAPIPublishes(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	user, err := models.NewUser(deps.DB(), &models.User{
+		Username:     "someUserName",
+		PasswordHash: "someHashOfPassword",
+	})
+	require.NoError(t, err)
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+
+	payloads := []string{}
+	deps.PubSub().Subscribe(utils.MessageCreatedChannel, func(payload string) {
+		payloads = append(payloads, payload)
+	})
+
+	r, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/channels/%d/messages", channel.ID), createMessageInput("some message data"))
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	c := echo.New().NewContext(r, w)
+	c.Set(middlewares.UserContextKey, user)
+	c.Set(middlewares.ChannelContextKey, channel)
+	require.NoError(t, NewSendMessageAPI(deps).Handler(c))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	message := models.Message{}
+	require.NoError(t, deps.DB().Last(&message).Error)
+	require.Equal(t, []string{fmt.Sprintf("%d", message.ID)}, payloads)
+}
+
+func TestThreadReplies(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendMessageAPI(deps),
+		NewGetMessagesAPI(deps),
+		NewGetRepliesAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some root message"}`)
+	require.Equal(t, http.StatusOK, status)
+	rootID := res.Result.(map[string]interface{})["ID"]
+
+	status, res = testutils.DoRequest(t, server, http.MethodPost, path, token, fmt.Sprintf(`{"data": "some reply", "parent_id": %v}`, rootID))
+	require.Equal(t, http.StatusOK, status)
+	replyID := res.Result.(map[string]interface{})["ID"]
+
+	// a reply to a reply is flattened into the root thread
+	status, res = testutils.DoRequest(t, server, http.MethodPost, path, otherToken, fmt.Sprintf(`{"data": "some nested reply", "parent_id": %v}`, replyID))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, rootID, res.Result.(map[string]interface{})["parent_id"])
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some orphan reply", "parent_id": 12345}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path, token, "")
+	require.Equal(t, http.StatusOK, status)
+	messages := res.Result.([]interface{})
+	require.Len(t, messages, 1)
+	root := messages[0].(map[string]interface{})
+	require.Equal(t, float64(2), root["reply_count"])
+	require.NotNil(t, root["last_reply_at"])
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/messages/%v/replies?page_size=1", rootID), token, "")
+	require.Equal(t, http.StatusOK, status)
+	replies := res.Result.([]interface{})
+	require.Len(t, replies, 1)
+	require.Equal(t, "some reply", replies[0].(map[string]interface{})["data"])
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/messages/%v/replies?page=2&page_size=1", rootID), token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "some nested reply", res.Result.([]interface{})[0].(map[string]interface{})["data"])
+}
+
+func TestThreadRepliesPrivateChannel(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewGetRepliesAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+	message, err := models.NewMessage(deps.DB(), &models.Message{Data: "some hidden message", ChannelID: private.ID})
+	require.NoError(t, err)
+
+	status, _ := testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/messages/%d/replies", message.ID), token, "")
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestSendMessageRequiresVerifiedEmail(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewSendMessageAPI(deps)}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some message"}`)
+	require.Equal(t, http.StatusOK, status)
+
+	os.Setenv(middlewares.RequireVerifiedEmailEnv, "true")
+	defer os.Unsetenv(middlewares.RequireVerifiedEmailEnv)
+	status, res := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some message"}`)
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, utils.UnverifiedEmailMsg, res.Error)
+
+	require.NoError(t, models.SetUserEmail(deps.DB(), user, "someone@example.com"))
+	ok, err := models.VerifyUserEmail(deps.DB(), user.ID, "someone@example.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some message"}`)
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestEditAndDeleteMessage(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendMessageAPI(deps),
+		NewGetMessagesAPI(deps),
+		NewEditMessageAPI(deps),
+		NewDeleteMessageAPI(deps),
+		NewGetMessageRevisionsAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some mesage"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Nil(t, res.Result.(map[string]interface{})["edited_at"])
+	messagePath := fmt.Sprintf("/messages/%v", res.Result.(map[string]interface{})["ID"])
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPatch, messagePath, otherToken, `{"data": "not my message"}`)
+	require.Equal(t, http.StatusForbidden, status)
+
+	for _, data := range []string{"some message", "some message!"} {
+		status, res = testutils.DoRequest(t, server, http.MethodPatch, messagePath, token, fmt.Sprintf(`{"data": "%s"}`, data))
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, data, res.Result.(map[string]interface{})["data"])
+		require.NotNil(t, res.Result.(map[string]interface{})["edited_at"])
+	}
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, messagePath+"/revisions", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	revisions := res.Result.([]interface{})
+	require.Len(t, revisions, 2)
+	require.Equal(t, "some mesage", revisions[0].(map[string]interface{})["data"])
+	require.Equal(t, "some message", revisions[1].(map[string]interface{})["data"])
+
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, messagePath, otherToken, "")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, messagePath, token, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPatch, messagePath, token, `{"data": "too late"}`)
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, path, token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result.([]interface{}))
+
+	deleted := models.Message{}
+	require.NoError(t, deps.DB().Unscoped().Last(&deleted).Error)
+	require.True(t, deleted.DeletedAt.Valid)
+}
+
+func TestDeleteReply(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendMessageAPI(deps),
+		NewDeleteMessageAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some root message"}`)
+	require.Equal(t, http.StatusOK, status)
+	rootID := res.Result.(map[string]interface{})["ID"]
+
+	replyIDs := []interface{}{}
+	for _, data := range []string{"some reply", "some other reply"} {
+		status, res = testutils.DoRequest(t, server, http.MethodPost, path, token, fmt.Sprintf(`{"data": "%s", "parent_id": %v}`, data, rootID))
+		require.Equal(t, http.StatusOK, status)
+		replyIDs = append(replyIDs, res.Result.(map[string]interface{})["ID"])
+	}
+
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, fmt.Sprintf("/messages/%v", replyIDs[1]), token, "")
+	require.Equal(t, http.StatusOK, status)
+	root, err := models.GetMessageByID(deps.DB(), uint(rootID.(float64)))
+	require.NoError(t, err)
+	first, err := models.GetMessageByID(deps.DB(), uint(replyIDs[0].(float64)))
+	require.NoError(t, err)
+	require.Equal(t, 1, root.ReplyCount)
+	require.True(t, first.CreatedAt.Equal(*root.LastReplyAt))
+
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, fmt.Sprintf("/messages/%v", replyIDs[0]), token, "")
+	require.Equal(t, http.StatusOK, status)
+	root, err = models.GetMessageByID(deps.DB(), root.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, root.ReplyCount)
+	require.Nil(t, root.LastReplyAt)
+}
+
+func TestDeleteMessageWithReplies(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendMessageAPI(deps),
+		NewDeleteMessageAPI(deps),
+		NewGetRepliesAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/channels/%d/messages", channel.ID)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, path, token, `{"data": "some root message"}`)
+	require.Equal(t, http.StatusOK, status)
+	rootID := res.Result.(map[string]interface{})["ID"]
+	status, res = testutils.DoRequest(t, server, http.MethodPost, path, otherToken, fmt.Sprintf(`{"data": "some reply", "parent_id": %v}`, rootID))
+	require.Equal(t, http.StatusOK, status)
+	replyID := res.Result.(map[string]interface{})["ID"]
+
+	// the thread goes with its root, even replies by others
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, fmt.Sprintf("/messages/%v", rootID), token, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodGet, fmt.Sprintf("/messages/%v/replies", rootID), token, "")
+	require.Equal(t, http.StatusNotFound, status)
+	_, err = models.GetMessageByID(deps.DB(), uint(replyID.(float64)))
+	require.Error(t, err)
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, fmt.Sprintf("/messages/%v", replyID), otherToken, "")
+	require.Equal(t, http.StatusNotFound, status)
+}
+
 func createMessageInput(data string) io.Reader {
 	return strings.NewReader(fmt.Sprintf(`{"data": "%s"}`, data))
 }
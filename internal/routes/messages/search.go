@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type SearchMessagesAPI struct {
+	deps utils.Deps
+}
+
+func NewSearchMessagesAPI(deps utils.Deps) utils.Route {
+	return &SearchMessagesAPI{deps}
+}
+
+func (api *SearchMessagesAPI) Method() string { return http.MethodGet }
+func (api *SearchMessagesAPI) Path() string   { return "/messages/search" }
+func (api *SearchMessagesAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+// Handler accepts q plus the optional username, from and to (RFC 3339,
+// to is exclusive) filters and the usual page parameters.
+func (api *SearchMessagesAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "SearchMessagesAPI", "user": user})
+
+	search, err := parseMessageSearch(c)
+	if err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	results := []models.MessageSearchResult{}
+	pagination, err := utils.NewPaginator(c).Find(models.SearchMessages(api.deps.DB(), user.ID, search), &results)
+	if err != nil {
+		logger.WithError(err).Error("could not search messages")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithFields(logrus.Fields{"query": search.Query, "resultCount": len(results)}).Debug("searched messages")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, results, pagination))
+}
+
+func parseMessageSearch(c echo.Context) (*models.MessageSearch, error) {
+	search := &models.MessageSearch{
+		Query:    strings.TrimSpace(c.QueryParam("q")),
+		Username: c.QueryParam("username"),
+	}
+	if search.Query == "" {
+		return nil, errors.New("missing query")
+	}
+
+	for param, dest := range map[string]**time.Time{"from": &search.From, "to": &search.To} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+		*dest = &t
+	}
+
+	return search, nil
+}
@@ -0,0 +1,106 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchMessagesAPI(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	if !deps.DB().Migrator().HasTable(models.MessageSearchTable) {
+		t.Skip("sqlite was built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+	testSearchMessagesAPI(t, deps, true)
+}
+
+// TestSearchMessagesAPIWithoutFTS runs even without -tags sqlite_fts5.
+func TestSearchMessagesAPIWithoutFTS(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	for _, statement := range []string{
+		"DROP TRIGGER IF EXISTS messages_fts_insert",
+		"DROP TRIGGER IF EXISTS messages_fts_delete",
+		"DROP TRIGGER IF EXISTS messages_fts_update",
+		"DROP TABLE IF EXISTS " + models.MessageSearchTable,
+	} {
+		require.NoError(t, deps.DB().Exec(statement).Error)
+	}
+	testSearchMessagesAPI(t, deps, false)
+}
+
+// testSearchMessagesAPI only checks scores and snippets when ranked.
+func testSearchMessagesAPI(t *testing.T, deps utils.Deps, ranked bool) {
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewSearchMessagesAPI(deps), NewEditMessageAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	messages := []*models.Message{
+		{Data: "the deploy failed again", Username: "someUserName", ChannelID: channel.ID},
+		{Data: "deploy deploy deploy", Username: "someOtherUser", ChannelID: channel.ID},
+		{Data: "lunch anyone?", Username: "someUserName", ChannelID: channel.ID},
+		{Data: "secret deploy plans", Username: "someOtherUser", ChannelID: private.ID},
+	}
+	messages[0].CreatedAt = lastWeek
+	for _, message := range messages {
+		_, err := models.NewMessage(deps.DB(), message)
+		require.NoError(t, err)
+	}
+
+	search := func(query string) []interface{} {
+		status, res := testutils.DoRequest(t, server, http.MethodGet, "/messages/search?"+query, token, "")
+		require.Equal(t, http.StatusOK, status, res.Error)
+		return res.Result.([]interface{})
+	}
+
+	results := search("q=deploy")
+	require.Len(t, results, 2)
+	if ranked {
+		best := results[0].(map[string]interface{})
+		require.Equal(t, "deploy deploy deploy", best["data"])
+		require.Contains(t, best["snippet"], models.SnippetStart+"deploy"+models.SnippetStop)
+		require.Greater(t, best["score"], results[1].(map[string]interface{})["score"])
+	}
+
+	require.Len(t, search("q=deploy&username=someUserName"), 1)
+	require.Len(t, search("q=deploy+failed"), 1)
+	require.Empty(t, search("q="+url.QueryEscape(`deploy" OR "lunch`))) // operators are matched literally
+	require.Empty(t, search("q=dinner"))
+	require.Empty(t, search("q=%21%21%21"))
+
+	since := url.QueryEscape(lastWeek.Add(time.Hour).Format(time.RFC3339))
+	results = search("q=deploy&from=" + since)
+	require.Len(t, results, 1)
+	require.Equal(t, "deploy deploy deploy", results[0].(map[string]interface{})["data"])
+	require.Len(t, search("q=deploy&to="+since), 1)
+
+	path := fmt.Sprintf("/messages/%d", messages[2].ID)
+	status, _ := testutils.DoRequest(t, server, http.MethodPatch, path, token, `{"data": "deploy lunch?"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, search("q=deploy"), 3)
+	require.NoError(t, deps.DB().Delete(messages[2]).Error)
+	require.Len(t, search("q=deploy"), 2)
+
+	for _, query := range []string{"", "q=", "q=deploy&from=yesterday"} {
+		status, _ = testutils.DoRequest(t, server, http.MethodGet, "/messages/search?"+query, token, "")
+		require.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
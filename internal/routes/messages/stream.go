@@ -0,0 +1,147 @@
+package messages
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 512
+)
+
+var (
+	upgrader = websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true }, // auth is done via token header, not cookies
+	}
+)
+
+type StreamMessagesAPI struct {
+	deps            utils.Deps
+	authCheckPeriod time.Duration
+}
+
+func NewStreamMessagesAPI(deps utils.Deps) utils.Route {
+	return &StreamMessagesAPI{deps, middlewares.AuthCheckPeriod}
+}
+
+func (api *StreamMessagesAPI) Method() string { return http.MethodGet }
+func (api *StreamMessagesAPI) Path() string   { return "/messages/stream" }
+func (api *StreamMessagesAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps, models.ScopeMessagesRead)}
+}
+
+func (api *StreamMessagesAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "StreamMessagesAPI", "user": user})
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// upgrader has already written an error response
+		logger.WithError(err).Warn("could not upgrade connection")
+		return nil
+	}
+
+	client := api.deps.Hub().Subscribe()
+	logger.Debug("stream connected")
+
+	lapsed := middlewares.WatchAuth(api.deps, c, api.authCheckPeriod)
+	go writeMessages(conn, client, newChannelFilter(api.deps.DB(), api.deps.Hub(), user, logger), lapsed, logger)
+	readMessages(conn, logger)
+
+	client.Close()
+	logger.Debug("stream disconnected")
+	return nil
+}
+
+// readMessages discards anything the client sends and only returns once the
+// connection is closed or stops answering pings.
+func readMessages(conn *websocket.Conn, logger *logrus.Entry) {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logger.WithError(err).Warn("unexpected stream close")
+			}
+			return
+		}
+	}
+}
+
+// writeMessages closes the connection once the user's credentials lapse, which
+// also ends readMessages.
+func writeMessages(conn *websocket.Conn, client *utils.HubClient, canRead func(*models.Message) bool, lapsed <-chan struct{}, logger *logrus.Entry) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-client.Messages():
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if !canRead(message) {
+				continue
+			}
+			if err := conn.WriteJSON(utils.NewSuccessResponse(message)); err != nil {
+				logger.WithError(err).Warn("could not write message to stream")
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-lapsed:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, utils.InvalidAuthInfo))
+			return
+		}
+	}
+}
+
+// newChannelFilter drops hub messages posted to channels the user cannot read.
+// Access is cached per channel until the hub reports that it changed, so only
+// the first message of a channel costs a query. It is only called from the
+// connection's writer, so the cache needs no lock.
+func newChannelFilter(db *gorm.DB, hub *utils.Hub, user *models.User, logger *logrus.Entry) func(*models.Message) bool {
+	type access struct {
+		ok      bool
+		version uint64
+	}
+	cache := map[uint]access{}
+	return func(message *models.Message) bool {
+		// read before checking, so a change during the check is caught next time
+		version := hub.ChannelAccessVersion(message.ChannelID)
+		if cached, found := cache[message.ChannelID]; found && cached.version == version {
+			return cached.ok
+		}
+		ok, err := models.CanReadChannelByID(db, message.ChannelID, user.ID)
+		if err != nil {
+			logger.WithError(err).WithField("channel", message.ChannelID).Warn("could not check channel access")
+			return false
+		}
+		cache[message.ChannelID] = access{ok, version}
+		return ok
+	}
+}
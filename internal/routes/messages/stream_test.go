@@ -0,0 +1,198 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamMessagesAPI(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSendMessageAPI(deps),
+		NewStreamMessagesAPI(deps),
+	}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	first := dialStream(t, server, token)
+	defer first.Close()
+	second := dialStream(t, server, token)
+	defer second.Close()
+	waitForClients(t, deps, 2)
+
+	channel, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+
+	body := createMessageInput("some streamed message")
+	r, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/channels/%d/messages", server.URL, channel.ID), body)
+	require.NoError(t, err)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	r.Header.Set(middlewares.JwtRequestHeader, token)
+	res, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		event := utils.Response{}
+		require.NoError(t, conn.ReadJSON(&event))
+		message := event.Result.(map[string]interface{})
+		require.Equal(t, "some streamed message", message["data"])
+		require.Equal(t, user.Username, message["username"])
+	}
+}
+
+func TestStreamMessagesAPIPrivateChannel(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewStreamMessagesAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	general, err := models.GetChannelByName(deps.DB(), models.DefaultChannelName)
+	require.NoError(t, err)
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+
+	conn := dialStream(t, server, token)
+	defer conn.Close()
+	waitForClients(t, deps, 1)
+
+	hidden, err := models.NewMessage(deps.DB(), &models.Message{Data: "some hidden message", ChannelID: private.ID})
+	require.NoError(t, err)
+	deps.Hub().Broadcast(hidden)
+	visible, err := models.NewMessage(deps.DB(), &models.Message{Data: "some visible message", ChannelID: general.ID})
+	require.NoError(t, err)
+	deps.Hub().Broadcast(visible)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	event := utils.Response{}
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, "some visible message", event.Result.(map[string]interface{})["data"])
+}
+
+func TestChannelFilterCachesAccess(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	user, _ := testutils.NewUserWithToken(t, deps, "someUserName")
+	private, err := models.NewChannel(deps.DB(), &models.Channel{Name: "somePrivateChannel", Private: true})
+	require.NoError(t, err)
+	message := &models.Message{Data: "some message", ChannelID: private.ID}
+	canRead := newChannelFilter(deps.DB(), deps.Hub(), user, deps.Logger().WithField("test", t.Name()))
+	require.False(t, canRead(message))
+
+	// cached until access changes, on any instance
+	require.NoError(t, models.AddChannelMember(deps.DB(), private.ID, user.ID))
+	require.False(t, canRead(message))
+	require.NoError(t, utils.PublishChannelAccessChanged(deps.PubSub(), private.ID))
+	require.True(t, canRead(message))
+
+	require.NoError(t, models.RemoveChannelMember(deps.DB(), private.ID, user.ID))
+	require.True(t, canRead(message))
+	require.NoError(t, utils.PublishChannelAccessChanged(deps.PubSub(), private.ID))
+	require.False(t, canRead(message))
+}
+
+func TestStreamMessagesAPIDisconnect(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewStreamMessagesAPI(deps)}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	conn := dialStream(t, server, token)
+	waitForClients(t, deps, 1)
+
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	conn.Close()
+	waitForClients(t, deps, 0)
+}
+
+func TestStreamMessagesAPIClosesWhenAuthLapses(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	api := NewStreamMessagesAPI(deps).(*StreamMessagesAPI)
+	api.authCheckPeriod = 10 * time.Millisecond
+	server := httptest.NewServer(utils.NewServer([]utils.Route{api}))
+	defer server.Close()
+
+	user, token := testutils.NewUserWithToken(t, deps, "someUserName")
+
+	conn := dialStream(t, server, token)
+	defer conn.Close()
+	_, claims, err := utils.ValidateJWTClaims(deps.DB(), deps.KeyRing(), token)
+	require.NoError(t, err)
+	require.NoError(t, models.RevokeToken(deps.DB(), claims.Id, time.Unix(claims.ExpiresAt, 0)))
+	requireStreamClosed(t, conn)
+
+	// expiry needs no check
+	api.authCheckPeriod = time.Hour
+	token, err = utils.NewJWT(deps.KeyRing(), user, "", 2*time.Second)
+	require.NoError(t, err)
+	conn = dialStream(t, server, token)
+	defer conn.Close()
+	requireStreamClosed(t, conn)
+	waitForClients(t, deps, 0)
+}
+
+func requireStreamClosed(t *testing.T, conn *websocket.Conn) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+}
+
+func TestStreamMessagesAPIInvalidToken(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewStreamMessagesAPI(deps)}))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set(middlewares.JwtRequestHeader, "notAToken")
+	_, res, err := websocket.DefaultDialer.Dial(streamURL(server), header)
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	require.Equal(t, 0, deps.Hub().ClientCount())
+}
+
+func dialStream(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	header := http.Header{}
+	header.Set(middlewares.JwtRequestHeader, token)
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL(server), header)
+	require.NoError(t, err)
+	return conn
+}
+
+func streamURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/messages/stream"
+}
+
+func waitForClients(t *testing.T, deps utils.Deps, count int) {
+	require.Eventually(t, func() bool {
+		return deps.Hub().ClientCount() == count
+	}, 5*time.Second, 10*time.Millisecond)
+}
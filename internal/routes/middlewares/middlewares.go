@@ -2,6 +2,9 @@ package middlewares
 
 import (
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/Krajiyah/nimble-interview-backend/internal/models"
 	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
@@ -9,28 +12,223 @@ import (
 )
 
 const (
-	JwtRequestHeader = "X-TOKEN"
-	UserContextKey   = "user"
+	JwtRequestHeader  = "X-TOKEN"
+	UserContextKey    = "user"
+	ClaimsContextKey  = "claims"
+	APIKeyContextKey  = "apiKey"
+	ChannelContextKey = "channel"
+	MessageContextKey = "message"
+
+	bearerPrefix        = "Bearer "
+	apiKeyTouchInterval = time.Minute
+
+	// AuthCheckPeriod is how often WatchAuth checks whether credentials were
+	// revoked, so revoking them ends streams within it.
+	AuthCheckPeriod = 30 * time.Second
+
+	RequireVerifiedEmailEnv = "REQUIRE_VERIFIED_EMAIL"
 )
 
-func UserAuthMiddleware(deps utils.Deps) echo.MiddlewareFunc {
+// UserAuthMiddleware accepts a JWT in X-TOKEN, or an API key as a bearer
+// token when the key was granted every one of scopes. Routes without scopes
+// cannot be used with API keys at all.
+func UserAuthMiddleware(deps utils.Deps, scopes ...string) echo.MiddlewareFunc {
 	return func(f echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			logger := deps.Logger().WithContext(c.Request().Context()).WithField("middleware", "UserAuthMiddleware")
 
 			token := c.Request().Header.Get(JwtRequestHeader)
+			authorization := c.Request().Header.Get(echo.HeaderAuthorization)
+			if token == "" && strings.HasPrefix(authorization, bearerPrefix) {
+				return apiKeyAuth(deps, c, f, strings.TrimPrefix(authorization, bearerPrefix), scopes)
+			}
 			if token == "" {
 				logger.Warn("missing token in header")
 				return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
 			}
 
-			user, err := utils.ValidateJWT(deps.DB(), token)
+			user, claims, err := utils.ValidateJWTClaims(deps.DB(), deps.KeyRing(), token)
 			if err != nil {
 				logger.Warn("invalid auth token")
 				return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
 			}
 
 			c.Set(UserContextKey, user)
+			c.Set(ClaimsContextKey, claims)
+			return f(c)
+		}
+	}
+}
+
+func apiKeyAuth(deps utils.Deps, c echo.Context, f echo.HandlerFunc, key string, scopes []string) error {
+	logger := deps.Logger().WithContext(c.Request().Context()).WithField("middleware", "UserAuthMiddleware")
+
+	apiKey, err := models.GetAPIKeyByHash(deps.DB(), utils.HashSecretToken(key))
+	if err != nil {
+		logger.WithError(err).Warn("invalid, expired or deleted api key")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithField("apiKey", apiKey.ID)
+
+	if len(scopes) == 0 || !apiKey.Scopes.Has(scopes...) {
+		logger.WithField("scopes", scopes).Warn("api key lacks scopes")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+	}
+
+	user, err := models.GetUserByID(deps.DB(), apiKey.UserID)
+	if err != nil {
+		logger.WithError(err).Warn("could not find api key user")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	if err := models.TouchAPIKey(deps.DB(), apiKey, apiKeyTouchInterval); err != nil {
+		logger.WithError(err).Error("could not record api key use")
+	}
+
+	c.Set(UserContextKey, user)
+	c.Set(APIKeyContextKey, apiKey)
+	return f(c)
+}
+
+// WatchAuth must run after UserAuthMiddleware. The returned channel is closed
+// once the JWT or API key the request was authenticated with expires, or is
+// found revoked when checked every period, so long-lived streams can end. It
+// stops watching when the request ends.
+func WatchAuth(deps utils.Deps, c echo.Context, period time.Duration) <-chan struct{} {
+	// echo reuses c once the handler returns, so the goroutine must not use it
+	ctx := c.Request().Context()
+	logger := deps.Logger().WithContext(ctx).WithField("middleware", "WatchAuth")
+	token := c.Request().Header.Get(JwtRequestHeader)
+	check := func() error {
+		_, err := utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+		return err
+	}
+	var expiresAt time.Time
+	if apiKey, ok := c.Get(APIKeyContextKey).(*models.APIKey); ok {
+		check = func() error {
+			_, err := models.GetAPIKeyByHash(deps.DB(), apiKey.KeyHash)
+			return err
+		}
+		if apiKey.ExpiresAt != nil {
+			expiresAt = *apiKey.ExpiresAt
+		}
+	} else {
+		expiresAt = time.Unix(RequireClaims(c).ExpiresAt, 0)
+	}
+
+	lapsed := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		var expired <-chan time.Time
+		if !expiresAt.IsZero() {
+			timer := time.NewTimer(time.Until(expiresAt))
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-expired:
+				logger.Debug("credentials expired")
+				close(lapsed)
+				return
+			case <-ticker.C:
+				if err := check(); err != nil {
+					logger.WithError(err).Debug("credentials revoked")
+					close(lapsed)
+					return
+				}
+			}
+		}
+	}()
+	return lapsed
+}
+
+// VerifiedUserMiddleware must run after UserAuthMiddleware. It rejects users
+// without a verified email when REQUIRE_VERIFIED_EMAIL is true.
+func VerifiedUserMiddleware(deps utils.Deps) echo.MiddlewareFunc {
+	return func(f echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := RequireUser(c)
+			if os.Getenv(RequireVerifiedEmailEnv) == "true" && !user.Verified {
+				deps.Logger().WithContext(c.Request().Context()).WithField("middleware", "VerifiedUserMiddleware").Warn("user email not verified")
+				return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.UnverifiedEmailMsg))
+			}
+			return f(c)
+		}
+	}
+}
+
+// ChannelAccessMiddleware must run after UserAuthMiddleware. It loads the
+// channel from the :id path param and rejects users who may not read it.
+func ChannelAccessMiddleware(deps utils.Deps) echo.MiddlewareFunc {
+	return func(f echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := RequireUser(c)
+			logger := deps.Logger().WithContext(c.Request().Context()).WithField("middleware", "ChannelAccessMiddleware")
+
+			id, err := utils.ParseIDParam(c, "id")
+			if err != nil {
+				logger.WithError(err).Warn("invalid channel id")
+				return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+			}
+
+			channel, err := models.GetChannelByID(deps.DB(), id)
+			if err != nil {
+				logger.WithError(err).Warn("could not find channel")
+				return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+			}
+
+			ok, err := channel.CanRead(deps.DB(), user.ID)
+			if err != nil {
+				logger.WithError(err).Error("could not check channel membership")
+				return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+			}
+			if !ok {
+				logger.WithField("channel", channel.ID).Warn("user is not a member of private channel")
+				return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+			}
+
+			c.Set(ChannelContextKey, channel)
+			return f(c)
+		}
+	}
+}
+
+// MessageAccessMiddleware must run after UserAuthMiddleware. It loads the
+// message from the :id path param and rejects users who may not read its channel.
+func MessageAccessMiddleware(deps utils.Deps) echo.MiddlewareFunc {
+	return func(f echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := RequireUser(c)
+			logger := deps.Logger().WithContext(c.Request().Context()).WithField("middleware", "MessageAccessMiddleware")
+
+			id, err := utils.ParseIDParam(c, "id")
+			if err != nil {
+				logger.WithError(err).Warn("invalid message id")
+				return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+			}
+
+			message, err := models.GetMessageByID(deps.DB(), id)
+			if err != nil {
+				logger.WithError(err).Warn("could not find message")
+				return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+			}
+
+			ok, err := models.CanReadChannelByID(deps.DB(), message.ChannelID, user.ID)
+			if err != nil {
+				logger.WithError(err).Warn("could not find message channel")
+				return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+			}
+			if !ok {
+				logger.WithField("message", message.ID).Warn("user is not a member of message channel")
+				return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.ForbiddenMsg))
+			}
+
+			c.Set(MessageContextKey, message)
 			return f(c)
 		}
 	}
@@ -39,3 +237,15 @@ func UserAuthMiddleware(deps utils.Deps) echo.MiddlewareFunc {
 func RequireUser(c echo.Context) *models.User {
 	return c.Get(UserContextKey).(*models.User)
 }
+
+func RequireClaims(c echo.Context) *utils.Claims {
+	return c.Get(ClaimsContextKey).(*utils.Claims)
+}
+
+func RequireChannel(c echo.Context) *models.Channel {
+	return c.Get(ChannelContextKey).(*models.Channel)
+}
+
+func RequireMessage(c echo.Context) *models.Message {
+	return c.Get(MessageContextKey).(*models.Message)
+}
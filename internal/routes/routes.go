@@ -1,6 +1,10 @@
 package routes
 
 import (
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/channels"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/conversations"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/jwks"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/messages"
 	"github.com/Krajiyah/nimble-interview-backend/internal/routes/users"
 	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
 )
@@ -9,5 +13,46 @@ func GetAllRoutes(deps utils.Deps) []utils.Route {
 	return []utils.Route{
 		users.NewSignupAPI(deps),
 		users.NewLoginAPI(deps),
+		users.NewRefreshTokenAPI(deps),
+		users.NewLogoutAPI(deps),
+		users.NewLogoutAllAPI(deps),
+		users.NewChangePasswordAPI(deps),
+		users.NewForgotPasswordAPI(deps),
+		users.NewResetPasswordAPI(deps),
+		users.NewSetEmailAPI(deps),
+		users.NewVerifyEmailAPI(deps),
+		users.NewTwoFactorSetupAPI(deps),
+		users.NewTwoFactorConfirmAPI(deps),
+		users.NewTwoFactorLoginAPI(deps),
+		users.NewOIDCStartAPI(deps),
+		users.NewOIDCCallbackAPI(deps),
+		users.NewCreateAPIKeyAPI(deps),
+		users.NewGetAPIKeysAPI(deps),
+		users.NewDeleteAPIKeyAPI(deps),
+		jwks.NewGetJWKSAPI(deps),
+		channels.NewCreateChannelAPI(deps),
+		channels.NewGetChannelsAPI(deps),
+		channels.NewGetChannelAPI(deps),
+		channels.NewUpdateChannelAPI(deps),
+		channels.NewDeleteChannelAPI(deps),
+		channels.NewGetChannelMembersAPI(deps),
+		channels.NewAddChannelMemberAPI(deps),
+		channels.NewRemoveChannelMemberAPI(deps),
+		conversations.NewSendDirectMessageAPI(deps),
+		conversations.NewGetDirectMessagesAPI(deps),
+		conversations.NewGetConversationsAPI(deps),
+		messages.NewSendMessageAPI(deps),
+		messages.NewGetMessagesAPI(deps),
+		messages.NewGetRepliesAPI(deps),
+		messages.NewEditMessageAPI(deps),
+		messages.NewDeleteMessageAPI(deps),
+		messages.NewGetMessageRevisionsAPI(deps),
+		messages.NewAddReactionAPI(deps),
+		messages.NewRemoveReactionAPI(deps),
+		messages.NewMarkReadAPI(deps),
+		messages.NewGetUnreadAPI(deps),
+		messages.NewSearchMessagesAPI(deps),
+		messages.NewStreamMessagesAPI(deps),
+		messages.NewMessageEventsAPI(deps),
 	}
 }
@@ -0,0 +1,150 @@
+package users
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+type createAPIKeyInput struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // RFC 3339, never expires when omitted
+}
+
+type createAPIKeyResponse struct {
+	APIKey *models.APIKey `json:"api_key"`
+	Key    string         `json:"key"` // shown only once
+}
+
+type CreateAPIKeyAPI struct {
+	deps utils.Deps
+}
+
+func NewCreateAPIKeyAPI(deps utils.Deps) utils.Route {
+	return &CreateAPIKeyAPI{deps}
+}
+
+func (api *CreateAPIKeyAPI) Method() string { return http.MethodPost }
+func (api *CreateAPIKeyAPI) Path() string   { return "/users/api-keys" }
+func (api *CreateAPIKeyAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+func (api *CreateAPIKeyAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "CreateAPIKeyAPI", "user": user})
+
+	var input createAPIKeyInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Name == "" || len(input.Scopes) == 0 || !models.ScopeList(models.APIKeyScopes).Has(input.Scopes...) {
+		logger.WithField("scopes", input.Scopes).Warn("missing name or invalid scopes")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		logger.Warn("api key would already be expired")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	key, prefix, keyHash, err := utils.NewAPIKey()
+	if err != nil {
+		logger.WithError(err).Error("could not create api key")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	apiKey, err := models.NewAPIKey(api.deps.DB(), &models.APIKey{
+		UserID:    user.ID,
+		Name:      input.Name,
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	})
+	if err != nil {
+		logger.WithError(err).Error("could not store api key")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("apiKey", apiKey.ID).Debug("api key created")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(createAPIKeyResponse{APIKey: apiKey, Key: key}))
+}
+
+type GetAPIKeysAPI struct {
+	deps utils.Deps
+}
+
+func NewGetAPIKeysAPI(deps utils.Deps) utils.Route {
+	return &GetAPIKeysAPI{deps}
+}
+
+func (api *GetAPIKeysAPI) Method() string { return http.MethodGet }
+func (api *GetAPIKeysAPI) Path() string   { return "/users/api-keys" }
+func (api *GetAPIKeysAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler lists the user's keys, newest first, including expired ones.
+func (api *GetAPIKeysAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "GetAPIKeysAPI", "user": user})
+
+	apiKeys := []models.APIKey{}
+	pagination, err := utils.NewPaginator(c).Find(models.GetAPIKeysForUser(api.deps.DB(), user.ID), &apiKeys)
+	if err != nil {
+		logger.WithError(err).Error("could not get api keys")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("apiKeyCount", len(apiKeys)).Debug("got api keys")
+	return c.JSON(http.StatusOK, utils.NewPaginatedResponse(c, apiKeys, pagination))
+}
+
+type DeleteAPIKeyAPI struct {
+	deps utils.Deps
+}
+
+func NewDeleteAPIKeyAPI(deps utils.Deps) utils.Route {
+	return &DeleteAPIKeyAPI{deps}
+}
+
+func (api *DeleteAPIKeyAPI) Method() string { return http.MethodDelete }
+func (api *DeleteAPIKeyAPI) Path() string   { return "/users/api-keys/:id" }
+func (api *DeleteAPIKeyAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler revokes the key, it stops working immediately.
+func (api *DeleteAPIKeyAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "DeleteAPIKeyAPI", "user": user})
+
+	id, err := utils.ParseIDParam(c, "id")
+	if err != nil {
+		logger.WithError(err).Warn("invalid api key id")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+	logger = logger.WithField("apiKey", id)
+
+	ok, err := models.DeleteAPIKey(api.deps.DB(), user.ID, id)
+	if err != nil {
+		logger.WithError(err).Error("could not delete api key")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if !ok {
+		logger.Warn("could not find api key")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	logger.Debug("api key deleted")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse("api key deleted"))
+}
@@ -0,0 +1,106 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/channels"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAPIKey creates a key that can read channels and checks that it works.
+func newTestAPIKey(t *testing.T, server *httptest.Server, token string) string {
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/api-keys", token, `{"name": "someKey", "scopes": ["channels:read"]}`)
+	require.Equal(t, http.StatusOK, status)
+	key := res.Result.(map[string]interface{})["key"].(string)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", key, "")
+	require.Equal(t, http.StatusOK, status)
+	return key
+}
+
+func TestAPIKeyAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewCreateAPIKeyAPI(deps),
+		NewGetAPIKeysAPI(deps),
+		NewDeleteAPIKeyAPI(deps),
+		channels.NewCreateChannelAPI(deps),
+		channels.NewGetChannelsAPI(deps),
+	}))
+	defer server.Close()
+
+	_, token := testutils.NewUserWithToken(t, deps, "someUserName")
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUserName")
+
+	for _, body := range []string{
+		`{"scopes": ["channels:read"]}`,
+		`{"name": "someKey"}`,
+		`{"name": "someKey", "scopes": ["channels:read", "users:admin"]}`,
+		fmt.Sprintf(`{"name": "someKey", "scopes": ["channels:read"], "expires_at": "%s"}`, time.Now().Add(-time.Hour).Format(time.RFC3339)),
+	} {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/api-keys", token, body)
+		require.Equal(t, http.StatusBadRequest, status, body)
+	}
+
+	createKey := func(body string) (string, map[string]interface{}) {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/api-keys", token, body)
+		require.Equal(t, http.StatusOK, status)
+		result := res.Result.(map[string]interface{})
+		key := result["key"].(string)
+		apiKey := result["api_key"].(map[string]interface{})
+		require.True(t, strings.HasPrefix(key, "nmb_"))
+		require.Equal(t, key[:12], apiKey["prefix"])
+		require.NotContains(t, apiKey, "key_hash")
+		return key, apiKey
+	}
+	readKey, readAPIKey := createKey(`{"name": "someReadKey", "scopes": ["channels:read"]}`)
+	writeKey, _ := createKey(fmt.Sprintf(`{"name": "someWriteKey", "scopes": ["channels:read", "channels:write"], "expires_at": "%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339)))
+	require.Equal(t, []interface{}{"channels:read"}, readAPIKey["scopes"])
+	require.Nil(t, readAPIKey["expires_at"])
+
+	status, _ := testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", readKey, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodPost, "/channels", readKey, `{"name": "someChannel"}`)
+	require.Equal(t, http.StatusForbidden, status)
+	status, res := testutils.DoBearerRequest(t, server, http.MethodPost, "/channels", writeKey, `{"name": "someChannel"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "someChannel", res.Result.(map[string]interface{})["name"])
+
+	// keys cannot manage the account, not even other keys
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/users/api-keys", readKey, "")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", "nmb_someMadeUpKey", "")
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/users/api-keys", token, "")
+	require.Equal(t, http.StatusOK, status)
+	apiKeys := res.Result.([]interface{})
+	require.Len(t, apiKeys, 2)
+	require.Equal(t, "someWriteKey", apiKeys[0].(map[string]interface{})["name"])
+	require.NotNil(t, apiKeys[1].(map[string]interface{})["last_used_at"])
+	status, res = testutils.DoRequest(t, server, http.MethodGet, "/users/api-keys", otherToken, "")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, res.Result)
+
+	path := fmt.Sprintf("/users/api-keys/%v", readAPIKey["ID"])
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, path, otherToken, "")
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodDelete, path, token, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", readKey, "")
+	require.Equal(t, http.StatusForbidden, status)
+
+	require.NoError(t, deps.DB().Model(&models.APIKey{}).Where("name = ?", "someWriteKey").Update("expires_at", time.Now().Add(-time.Second)).Error)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", writeKey, "")
+	require.Equal(t, http.StatusForbidden, status)
+}
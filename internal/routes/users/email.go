@@ -0,0 +1,165 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	emailVerificationDuration = time.Hour * 24
+)
+
+type emailInput struct {
+	Email string `json:"email"`
+}
+
+type verifyEmailInput struct {
+	Token string `json:"token"`
+}
+
+type SetEmailAPI struct {
+	deps utils.Deps
+}
+
+func NewSetEmailAPI(deps utils.Deps) utils.Route {
+	return &SetEmailAPI{deps}
+}
+
+func (api *SetEmailAPI) Method() string { return http.MethodPost }
+func (api *SetEmailAPI) Path() string   { return "/users/email" }
+func (api *SetEmailAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler replaces the user's email with an unverified one and mails it a
+// verification link.
+func (api *SetEmailAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "SetEmailAPI", "user": user})
+
+	var input emailInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if err := checkEmail(api.deps.DB(), input.Email, user.ID); err != nil {
+		logger.WithError(err).Warn("invalid email")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	err := models.SetUserEmail(api.deps.DB(), user, input.Email)
+	if utils.IsUniqueViolation(err) {
+		logger.Warn("email already in use")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+	if err != nil {
+		logger.WithError(err).Error("could not set email")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	sendEmailVerification(api.deps, logger, user, input.Email)
+	logger.Debug("email set")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(user))
+}
+
+type VerifyEmailAPI struct {
+	deps utils.Deps
+}
+
+func NewVerifyEmailAPI(deps utils.Deps) utils.Route {
+	return &VerifyEmailAPI{deps}
+}
+
+func (api *VerifyEmailAPI) Method() string                     { return http.MethodPost }
+func (api *VerifyEmailAPI) Path() string                       { return "/users/email/verify" }
+func (api *VerifyEmailAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler needs no login, the token itself proves who it was sent to.
+func (api *VerifyEmailAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "VerifyEmailAPI")
+
+	var input verifyEmailInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Token == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	userID, email, err := utils.ValidateEmailVerificationJWT(api.deps.KeyRing(), input.Token)
+	if err != nil {
+		logger.WithError(err).Warn("invalid verification token")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithField("user", userID)
+
+	ok, err := models.VerifyUserEmail(api.deps.DB(), userID, email)
+	if err != nil {
+		logger.WithError(err).Error("could not verify email")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if !ok {
+		logger.Warn("email changed since verification token was sent")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	user, err := models.GetUserByID(api.deps.DB(), userID)
+	if err != nil {
+		logger.WithError(err).Error("could not find verified user")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("email verified")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(user))
+}
+
+// checkEmail accepts bare addresses not yet used by anyone but userID.
+func checkEmail(db *gorm.DB, email string, userID uint) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if address.Address != email {
+		return errors.New("email must be a bare address")
+	}
+	other, err := models.GetUserByEmail(db, email)
+	if err == nil && other.ID != userID {
+		return errors.New("email already in use")
+	}
+	return nil
+}
+
+// sendEmailVerification mails in the background, failures are only logged
+// since the user can always ask for another link.
+func sendEmailVerification(deps utils.Deps, logger *logrus.Entry, user *models.User, email string) {
+	token, err := utils.NewEmailVerificationJWT(deps.KeyRing(), user, email, emailVerificationDuration)
+	if err != nil {
+		logger.WithError(err).Error("could not create verification token")
+		return
+	}
+	verification := &utils.Mail{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("To confirm %s as the email of %s, open %s within %v.",
+			email, user.Username, newAppLink("/verify-email", token), emailVerificationDuration),
+	}
+	go func() {
+		if err := deps.Mailer().Send(verification); err != nil {
+			logger.WithError(err).Error("could not send verification mail")
+		}
+	}()
+}
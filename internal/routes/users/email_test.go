@@ -0,0 +1,101 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailVerificationAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSignupAPI(deps),
+		NewSetEmailAPI(deps),
+		NewVerifyEmailAPI(deps),
+		NewForgotPasswordAPI(deps),
+	}))
+	defer server.Close()
+	mailer := deps.Mailer().(*utils.MemoryMailer)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", `{"username": "testUsername", "password": "testPassword", "email": "someone@example.com"}`)
+	require.Equal(t, http.StatusOK, status)
+	result := res.Result.(map[string]interface{})
+	require.Equal(t, "someone@example.com", result["user"].(map[string]interface{})["email"])
+	require.Equal(t, false, result["user"].(map[string]interface{})["verified"])
+	token := result["token"].(string)
+
+	verify := func(verificationToken string) int {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/email/verify", "", fmt.Sprintf(`{"token": "%s"}`, verificationToken))
+		return status
+	}
+	firstToken := waitForMailToken(t, mailer, 1, "someone@example.com")
+	require.Equal(t, http.StatusForbidden, verify(token)) // access tokens are not verification tokens
+
+	// changing the email again makes links sent to the old one useless
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/email", token, `{"email": "someone.else@example.com"}`)
+	require.Equal(t, http.StatusOK, status)
+	secondToken := waitForMailToken(t, mailer, 2, "someone.else@example.com")
+	require.Equal(t, http.StatusForbidden, verify(firstToken))
+	require.Equal(t, http.StatusOK, verify(secondToken))
+
+	user, err := models.GetUserByUsername(deps.DB(), "testUsername")
+	require.NoError(t, err)
+	require.True(t, user.Verified)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), secondToken)
+	require.Error(t, err) // and verification tokens are not access tokens
+
+	// reset links now go to the verified email
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/password/forgot", "", `{"username": "testUsername"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Eventually(t, func() bool { return len(mailer.Sent()) == 3 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, "someone.else@example.com", mailer.Sent()[2].To)
+
+	_, otherToken := testutils.NewUserWithToken(t, deps, "someOtherUsername")
+	for _, body := range []string{`{"email": "someone.else@example.com"}`, `{"email": "Someone.Else@Example.com"}`, `{"email": "not an email"}`, `{"email": "Someone <someone@example.com>"}`, `{}`} {
+		status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/email", otherToken, body)
+		require.Equal(t, http.StatusBadRequest, status, body)
+	}
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users", "", `{"username": "thirdUsername", "password": "testPassword", "email": "someone.else@example.com"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestUserEmailsAreUniqueRegardlessOfCase(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	// the index holds even when two requests both pass checkEmail
+	user, _ := testutils.NewUserWithToken(t, deps, "testUsername")
+	otherUser, _ := testutils.NewUserWithToken(t, deps, "someOtherUsername")
+	require.NoError(t, models.SetUserEmail(deps.DB(), user, "A@example.com"))
+	err = models.SetUserEmail(deps.DB(), otherUser, "a@example.com")
+	require.True(t, utils.IsUniqueViolation(err), err)
+
+	found, err := models.GetUserByEmail(deps.DB(), "a@EXAMPLE.com")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, found.ID)
+}
+
+// waitForMailToken waits for the count-th mail and returns the token linked
+// in it after checking its recipient.
+func waitForMailToken(t *testing.T, mailer *utils.MemoryMailer, count int, to string) string {
+	require.Eventually(t, func() bool { return len(mailer.Sent()) == count }, time.Second, 10*time.Millisecond)
+	mail := mailer.Sent()[count-1]
+	require.Equal(t, to, mail.To)
+	match := resetTokenPattern.FindStringSubmatch(mail.Body)
+	require.Len(t, match, 2)
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+	return token
+}
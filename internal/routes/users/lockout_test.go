@@ -0,0 +1,158 @@
+package users
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// attemptsDeps swaps the unit limiters for ones with a quick policy.
+type attemptsDeps struct {
+	utils.Deps
+	attempts   *utils.AttemptLimiter
+	ipAttempts *utils.AttemptLimiter
+}
+
+func (deps *attemptsDeps) Attempts() *utils.AttemptLimiter   { return deps.attempts }
+func (deps *attemptsDeps) IPAttempts() *utils.AttemptLimiter { return deps.ipAttempts }
+
+func TestLoginLockout(t *testing.T) {
+	os.Setenv("TRUST_PROXY", "true")
+	defer os.Unsetenv("TRUST_PROXY")
+
+	for name, newStore := range map[string]func(deps utils.Deps) utils.AttemptStore{
+		"memory":   func(utils.Deps) utils.AttemptStore { return utils.NewMemoryAttemptStore() },
+		"database": func(deps utils.Deps) utils.AttemptStore { return utils.NewDBAttemptStore(deps.DB()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			unitDeps, fileName, err := testutils.NewUnitDeps()
+			require.NoError(t, err)
+			defer os.Remove(fileName)
+			store := newStore(unitDeps)
+			policy := utils.AttemptPolicy{Threshold: 3, Lockout: 300 * time.Millisecond, MaxLockout: time.Minute}
+			ipPolicy := policy
+			ipPolicy.Threshold = 5
+			deps := &attemptsDeps{unitDeps, utils.NewAttemptLimiter(store, policy), utils.NewAttemptLimiter(store, ipPolicy)}
+			now := time.Now()
+			clock := func() time.Time { return now }
+			deps.attempts.SetClock(clock)
+			deps.ipAttempts.SetClock(clock)
+			server := httptest.NewServer(utils.NewServer([]utils.Route{NewSignupAPI(deps), NewLoginAPI(deps)}))
+			defer server.Close()
+
+			status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+			require.Equal(t, http.StatusOK, status)
+
+			login := func(ip, username, password string) (int, string) {
+				r, err := http.NewRequest(http.MethodPost, server.URL+"/users/login", strings.NewReader(createAuthBody(username, password)))
+				require.NoError(t, err)
+				r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				r.Header.Set(echo.HeaderXForwardedFor, ip)
+				w, err := http.DefaultClient.Do(r)
+				require.NoError(t, err)
+				w.Body.Close()
+				return w.StatusCode, w.Header.Get("Retry-After")
+			}
+			lockOut := func(ip string) {
+				i := 0
+				for i < policy.Threshold {
+					status, _ := login(ip, "testUsername", "badPassword")
+					require.Equal(t, http.StatusForbidden, status)
+					i++
+				}
+				status, retryAfter := login(ip, "testUsername", "testPassword")
+				require.Equal(t, http.StatusTooManyRequests, status)
+				require.Equal(t, "1", retryAfter)
+			}
+
+			// every lockout is twice as long as the last
+			lockOut("10.0.0.1")
+			now = now.Add(policy.Lockout)
+			lockOut("10.0.0.2")
+			now = now.Add(policy.Lockout)
+			status, _ = login("10.0.0.3", "testUsername", "testPassword")
+			require.Equal(t, http.StatusTooManyRequests, status)
+			now = now.Add(policy.Lockout)
+			status, _ = login("10.0.0.3", "testUsername", "testPassword")
+			require.Equal(t, http.StatusOK, status)
+
+			events := []models.AuditEvent{}
+			require.NoError(t, deps.DB().Order("id").Find(&events).Error)
+			require.Len(t, events, 2)
+			require.Equal(t, models.AuditLockout, events[0].Event)
+			require.NotNil(t, events[0].UserID)
+			require.Equal(t, "10.0.0.1", events[0].IP)
+			require.Equal(t, "login:testusername locked out for 300ms", events[0].Details)
+			require.Equal(t, "login:testusername locked out for 600ms", events[1].Details)
+
+			// an IP guessing many usernames is locked out for all of them
+			for _, username := range []string{"a", "b", "c", "d", "e"} {
+				status, _ = login("10.0.0.4", username, "somePassword")
+				require.Equal(t, http.StatusForbidden, status)
+			}
+			status, _ = login("10.0.0.4", "testUsername", "testPassword")
+			require.Equal(t, http.StatusTooManyRequests, status)
+			status, _ = login("10.0.0.5", "testUsername", "testPassword")
+			require.Equal(t, http.StatusOK, status)
+
+			require.NoError(t, deps.DB().Where("event = ?", models.AuditLockout).Order("id").Find(&events).Error)
+			require.Len(t, events, 3)
+			require.Nil(t, events[2].UserID)
+			require.Equal(t, "login-ip:10.0.0.4 locked out for 300ms", events[2].Details)
+		})
+	}
+}
+
+func TestDBAttemptStoreCountsConcurrentFailures(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	store := utils.NewDBAttemptStore(deps.DB())
+
+	const failures = 10
+	now := time.Now()
+	errs := make(chan error, failures)
+	i := 0
+	for i < failures {
+		go func() {
+			_, err := store.Fail("someKey", now)
+			errs <- err
+		}()
+		i++
+	}
+	i = 0
+	for i < failures {
+		require.NoError(t, <-errs)
+		i++
+	}
+	counter, err := store.Get("someKey")
+	require.NoError(t, err)
+	require.Equal(t, failures, counter.Failures)
+
+	// only one of two concurrent failures past the threshold locks the key out
+	locked, err := store.Lock(counter, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, locked)
+	locked, err = store.Lock(counter, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, locked)
+
+	// failures after the window start counting from one again
+	counter, err = store.Fail("someKey", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, counter.Failures)
+	require.Equal(t, 1, counter.Lockouts)
+	counter, err = store.Fail("someKey", now.Add(48*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, counter.Failures)
+	require.Equal(t, 0, counter.Lockouts)
+}
@@ -0,0 +1,242 @@
+package users
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcLoginDuration = time.Minute * 10
+)
+
+type oidcStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"` // open in a browser to sign in at the provider
+	LoginToken       string `json:"login_token"`       // keep in the app and send back to finish the login
+	ExpiresAt        int64  `json:"expires_at"`        // unix seconds
+}
+
+type oidcCallbackInput struct {
+	LoginToken string `json:"login_token"`
+	Code       string `json:"code"`
+	State      string `json:"state"`
+}
+
+type OIDCStartAPI struct {
+	deps utils.Deps
+}
+
+func NewOIDCStartAPI(deps utils.Deps) utils.Route {
+	return &OIDCStartAPI{deps}
+}
+
+func (api *OIDCStartAPI) Method() string                     { return http.MethodPost }
+func (api *OIDCStartAPI) Path() string                       { return "/users/oidc/:provider/start" }
+func (api *OIDCStartAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler starts an authorization code login with PKCE. The state, nonce and
+// code verifier never leave the backend.
+func (api *OIDCStartAPI) Handler(c echo.Context) error {
+	providerName := c.Param("provider")
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "OIDCStartAPI", "provider": providerName})
+
+	provider, err := api.deps.OIDC().Get(c.Request().Context(), providerName)
+	if err != nil {
+		logger.WithError(err).Warn("could not get oidc provider")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	secrets := make([]string, 4)
+	i := 0
+	for i < len(secrets) {
+		if secrets[i], _, err = utils.NewSecretToken(); err != nil {
+			logger.WithError(err).Error("could not create oidc login secrets")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+		i++
+	}
+	loginToken, state, nonce, verifier := secrets[0], secrets[1], secrets[2], secrets[3]
+
+	login, err := models.NewOIDCLogin(api.deps.DB(), &models.OIDCLogin{
+		TokenHash: utils.HashSecretToken(loginToken),
+		Provider:  provider.Name,
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oidcLoginDuration),
+	})
+	if err != nil {
+		logger.WithError(err).Error("could not create oidc login")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("oidc login started")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(oidcStartResponse{
+		AuthorizationURL: provider.AuthCodeURL(state, nonce, verifier),
+		LoginToken:       loginToken,
+		ExpiresAt:        login.ExpiresAt.Unix(),
+	}))
+}
+
+type OIDCCallbackAPI struct {
+	deps utils.Deps
+}
+
+func NewOIDCCallbackAPI(deps utils.Deps) utils.Route {
+	return &OIDCCallbackAPI{deps}
+}
+
+func (api *OIDCCallbackAPI) Method() string                     { return http.MethodPost }
+func (api *OIDCCallbackAPI) Path() string                       { return "/users/oidc/:provider/callback" }
+func (api *OIDCCallbackAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler finishes the login with the code and state the provider redirected
+// back with. A new identity is linked to the logged in user when the request
+// carries a token, and gets a new user otherwise.
+func (api *OIDCCallbackAPI) Handler(c echo.Context) error {
+	providerName := c.Param("provider")
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "OIDCCallbackAPI", "provider": providerName})
+
+	var input oidcCallbackInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.LoginToken == "" || input.Code == "" || input.State == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	var linkUser *models.User
+	if token := c.Request().Header.Get(middlewares.JwtRequestHeader); token != "" {
+		user, err := utils.ValidateJWT(api.deps.DB(), api.deps.KeyRing(), token)
+		if err != nil {
+			logger.WithError(err).Warn("invalid auth token")
+			return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+		}
+		linkUser = user
+	}
+
+	login, err := models.UseOIDCLogin(api.deps.DB(), utils.HashSecretToken(input.LoginToken))
+	if err != nil {
+		logger.WithError(err).Warn("invalid, used or expired login token")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	if login.Provider != providerName || subtle.ConstantTimeCompare([]byte(login.State), []byte(input.State)) != 1 {
+		logger.Warn("state does not match oidc login")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	provider, err := api.deps.OIDC().Get(c.Request().Context(), providerName)
+	if err != nil {
+		logger.WithError(err).Warn("could not get oidc provider")
+		return c.JSON(http.StatusNotFound, utils.NewErrorResponse(utils.NotFoundMsg))
+	}
+
+	identity, err := provider.Exchange(c.Request().Context(), input.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		logger.WithError(err).Warn("could not verify oidc login")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithField("subject", identity.Subject)
+
+	db := api.deps.DB().Begin()
+	user, err := userForIdentity(db, api.deps.Credentials(), provider.Name, identity, linkUser)
+	if err == errIdentityLinked {
+		db.Rollback()
+		logger.Warn("identity already linked to another user")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not find or create user for identity")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	// the same second step as LoginAPI for users with 2FA
+	var res utils.Response
+	if user.TOTPEnabled {
+		res, err = newChallengeResponse(api.deps.KeyRing(), user)
+	} else {
+		res, err = newAuthResponse(db, api.deps.KeyRing(), user, "")
+	}
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not build auth response")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for oidc login")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("id", user.ID).Debug("user logged in w/ oidc")
+	return c.JSON(http.StatusOK, res)
+}
+
+var errIdentityLinked = errors.New("identity linked to another user")
+
+// userForIdentity returns the user the identity is linked to, linking it to
+// linkUser or a new user first if it is not linked yet.
+func userForIdentity(db *gorm.DB, policy *utils.CredentialPolicy, provider string, identity *utils.OIDCIdentity, linkUser *models.User) (*models.User, error) {
+	existing, err := models.GetIdentity(db, provider, identity.Subject)
+	if err == nil {
+		if linkUser != nil && linkUser.ID != existing.UserID {
+			return nil, errIdentityLinked
+		}
+		return models.GetUserByID(db, existing.UserID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	user := linkUser
+	if user == nil {
+		user = &models.User{Username: identityUsername(db, policy, provider, identity)}
+		if identity.EmailVerified && checkEmail(db, identity.Email, 0) == nil {
+			user.Email = &identity.Email
+			user.Verified = true
+		}
+		// no password, one can be set through a password reset
+		if _, err := models.NewUser(db, user); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = models.NewIdentity(db, &models.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	return user, err
+}
+
+// identityUsername prefers the username or verified email the user has at the
+// provider, unless the policy rejects it or someone already took it here.
+func identityUsername(db *gorm.DB, policy *utils.CredentialPolicy, provider string, identity *utils.OIDCIdentity) string {
+	candidates := []string{identity.PreferredUsername}
+	if identity.EmailVerified {
+		candidates = append(candidates, identity.Email)
+	}
+	for _, candidate := range candidates {
+		if len(policy.CheckUsername(candidate)) > 0 {
+			continue
+		}
+		if _, err := models.GetUserByUsername(db, candidate); err == gorm.ErrRecordNotFound {
+			return candidate
+		}
+	}
+	return fmt.Sprintf("%s-%s", provider, identity.Subject)
+}
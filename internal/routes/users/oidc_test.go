@@ -0,0 +1,140 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// oidcDeps swaps the unit providers for the mock provider.
+type oidcDeps struct {
+	utils.Deps
+	providers *utils.OIDCProviders
+}
+
+func (deps *oidcDeps) OIDC() *utils.OIDCProviders { return deps.providers }
+
+func TestOIDCAPIs(t *testing.T) {
+	unitDeps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	provider := testutils.NewMockOIDCProvider(t)
+	defer provider.Close()
+	deps := &oidcDeps{unitDeps, utils.NewOIDCProviders([]utils.OIDCProviderConfig{provider.Config("mock")})}
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewOIDCStartAPI(deps),
+		NewOIDCCallbackAPI(deps),
+	}))
+	defer server.Close()
+
+	start := func() (string, string) {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/oidc/mock/start", "", "")
+		require.Equal(t, http.StatusOK, status)
+		result := res.Result.(map[string]interface{})
+		return result["authorization_url"].(string), result["login_token"].(string)
+	}
+	callback := func(token, loginToken, code, state string) (int, utils.Response) {
+		body := fmt.Sprintf(`{"login_token": "%s", "code": "%s", "state": "%s"}`, loginToken, code, state)
+		return testutils.DoRequest(t, server, http.MethodPost, "/users/oidc/mock/callback", token, body)
+	}
+	login := func(token string) (int, utils.Response) {
+		authorizationURL, loginToken := start()
+		code, state := provider.Authorize(t, authorizationURL)
+		return callback(token, loginToken, code, state)
+	}
+
+	provider.User = testutils.MockOIDCUser{Subject: "someSubject", Email: "someone@example.com", EmailVerified: true, PreferredUsername: "someUsername"}
+	status, res := login("")
+	require.Equal(t, http.StatusOK, status)
+	result := res.Result.(map[string]interface{})
+	user := result["user"].(map[string]interface{})
+	require.Equal(t, "someUsername", user["username"])
+	require.Equal(t, "someone@example.com", user["email"])
+	require.Equal(t, true, user["verified"])
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), result["token"].(string))
+	require.NoError(t, err)
+
+	status, res = login("")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, user["ID"], res.Result.(map[string]interface{})["user"].(map[string]interface{})["ID"])
+
+	// an intercepted redirect is useless without the login token of the app
+	// that started the login, and each login finishes once
+	authorizationURL, loginToken := start()
+	code, state := provider.Authorize(t, authorizationURL)
+	_, otherLoginToken := start()
+	status, _ = callback("", otherLoginToken, code, state)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = callback("", loginToken, code, state)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = callback("", loginToken, code, state)
+	require.Equal(t, http.StatusForbidden, status)
+
+	// the same username is not handed out twice
+	provider.User = testutils.MockOIDCUser{Subject: "someOtherSubject", PreferredUsername: "someUsername"}
+	status, res = login("")
+	require.Equal(t, http.StatusOK, status)
+	user = res.Result.(map[string]interface{})["user"].(map[string]interface{})
+	require.Equal(t, "mock-someOtherSubject", user["username"])
+	require.Nil(t, user["email"])
+
+	// logged in users link the identity to themselves
+	passwordUser, token := testutils.NewUserWithToken(t, deps, "somePasswordUsername")
+	provider.User = testutils.MockOIDCUser{Subject: "someThirdSubject"}
+	status, res = login(token)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(passwordUser.ID), res.Result.(map[string]interface{})["user"].(map[string]interface{})["ID"])
+	status, res = login("")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(passwordUser.ID), res.Result.(map[string]interface{})["user"].(map[string]interface{})["ID"])
+
+	provider.User = testutils.MockOIDCUser{Subject: "someSubject"}
+	status, _ = login(token)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/oidc/unknown/start", "", "")
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func TestOIDCProvidersDiscoverWithoutBlocking(t *testing.T) {
+	provider := testutils.NewMockOIDCProvider(t)
+	defer provider.Close()
+	// an issuer that never answers discovery until the test ends
+	started, hanging := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-hanging
+	}))
+	defer slow.Close()
+	defer close(hanging)
+
+	providers := utils.NewOIDCProviders([]utils.OIDCProviderConfig{
+		provider.Config("mock"),
+		{Name: "slow", Issuer: slow.URL, ClientID: "someClientID"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go providers.Get(ctx, "slow")
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := providers.Get(context.Background(), "mock")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "discovering one provider blocked another")
+	}
+
+}
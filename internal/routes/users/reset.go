@@ -0,0 +1,219 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	passwordResetDuration = time.Hour
+	defaultAppURL         = "http://localhost:1234"
+
+	forgotAttemptPrefix   = "forgot:"
+	forgotIPAttemptPrefix = "forgot-ip:"
+)
+
+type forgotPasswordInput struct {
+	Username string `json:"username"`
+}
+
+type resetPasswordInput struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type ForgotPasswordAPI struct {
+	deps utils.Deps
+}
+
+func NewForgotPasswordAPI(deps utils.Deps) utils.Route {
+	return &ForgotPasswordAPI{deps}
+}
+
+func (api *ForgotPasswordAPI) Method() string                     { return http.MethodPost }
+func (api *ForgotPasswordAPI) Path() string                       { return "/users/password/forgot" }
+func (api *ForgotPasswordAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler answers the same way whether or not the user exists or has a
+// verified email, so it cannot be used to find out which usernames are taken.
+// Every request counts as a failed attempt, so it cannot flood a mailbox.
+func (api *ForgotPasswordAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "ForgotPasswordAPI")
+
+	var input forgotPasswordInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Username == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	attemptKey := forgotAttemptPrefix + strings.ToLower(input.Username)
+	ipAttemptKey := forgotIPAttemptPrefix + c.RealIP()
+	if ok, err := allowAttempt(c, logger, api.deps.Attempts(), attemptKey); !ok {
+		return err
+	}
+	if ok, err := allowAttempt(c, logger, api.deps.IPAttempts(), ipAttemptKey); !ok {
+		return err
+	}
+	failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, nil)
+	failAttempt(api.deps, c, logger, api.deps.IPAttempts(), ipAttemptKey, nil)
+
+	res := utils.NewSuccessResponse("if the account exists, a reset link has been sent")
+
+	user, err := models.GetUserByUsername(api.deps.DB(), input.Username)
+	if err != nil {
+		logger.WithError(err).Info("password reset requested for unknown username")
+		return c.JSON(http.StatusOK, res)
+	}
+	logger = logger.WithField("user", user.ID)
+
+	if user.Email == nil || !user.Verified {
+		logger.Info("password reset requested for user without a verified email")
+		return c.JSON(http.StatusOK, res)
+	}
+
+	token, tokenHash, err := utils.NewSecretToken()
+	if err != nil {
+		logger.WithError(err).Error("could not create reset token")
+		return c.JSON(http.StatusOK, res)
+	}
+
+	_, err = models.NewPasswordReset(api.deps.DB(), &models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	})
+	if err != nil {
+		logger.WithError(err).Error("could not create password reset")
+		return c.JSON(http.StatusOK, res)
+	}
+
+	// sent in the background so response times do not give the user away
+	mail := newPasswordResetMail(user, token)
+	go func() {
+		if err := api.deps.Mailer().Send(mail); err != nil {
+			logger.WithError(err).Error("could not send password reset mail")
+		}
+	}()
+
+	logger.Debug("password reset requested")
+	return c.JSON(http.StatusOK, res)
+}
+
+// newPasswordResetMail is only ever sent to a verified email, the caller
+// makes sure the user has one.
+func newPasswordResetMail(user *models.User, token string) *utils.Mail {
+	return &utils.Mail{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of %s.\n\nTo choose a new password, open %s within %v.\n\nIf it was not you, ignore this mail.",
+			user.Username, newAppLink("/reset-password", token), passwordResetDuration),
+	}
+}
+
+// newAppLink points into the app, which hands the token back to the API.
+func newAppLink(path, token string) string {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = defaultAppURL
+	}
+	return fmt.Sprintf("%s%s?token=%s", appURL, path, url.QueryEscape(token))
+}
+
+type ResetPasswordAPI struct {
+	deps utils.Deps
+}
+
+func NewResetPasswordAPI(deps utils.Deps) utils.Route {
+	return &ResetPasswordAPI{deps}
+}
+
+func (api *ResetPasswordAPI) Method() string                     { return http.MethodPost }
+func (api *ResetPasswordAPI) Path() string                       { return "/users/password/reset" }
+func (api *ResetPasswordAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler sets the new password and logs the user out everywhere, since
+// whoever knew the old password may still hold a session.
+func (api *ResetPasswordAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "ResetPasswordAPI")
+
+	var input resetPasswordInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Token == "" || input.NewPassword == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	db := api.deps.DB().Begin()
+	reset, err := models.UsePasswordReset(db, utils.HashSecretToken(input.Token))
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Warn("invalid, used or expired reset token")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithField("user", reset.UserID)
+
+	user, err := models.GetUserByID(db, reset.UserID)
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not find reset user")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	// rolled back, so the token can be used again with a better password
+	if details := api.deps.Credentials().CheckPassword("new_password", input.NewPassword, user.Username); len(details) > 0 {
+		db.Rollback()
+		logger.WithField("details", details).Warn("new password rejected")
+		return c.JSON(http.StatusBadRequest, utils.NewFieldErrorResponse(details))
+	}
+
+	passwordHash, err := api.deps.Passwords().Hash(input.NewPassword)
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not hash password")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Model(user).Update("password_hash", passwordHash).Error; err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not update password")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.RevokeUserSessions(db, reset.UserID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not revoke sessions")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.DeleteUserAPIKeys(db, reset.UserID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not delete api keys")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for password reset")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	resetAttempts(logger, api.deps.Attempts(), loginAttemptKey(user.Username))
+	logger.Debug("password reset")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(user))
+}
@@ -0,0 +1,121 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/channels"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var resetTokenPattern = regexp.MustCompile(`token=([^\s]+)`)
+
+func TestPasswordResetAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSignupAPI(deps),
+		NewLoginAPI(deps),
+		NewRefreshTokenAPI(deps),
+		NewForgotPasswordAPI(deps),
+		NewResetPasswordAPI(deps),
+		NewCreateAPIKeyAPI(deps),
+		channels.NewGetChannelsAPI(deps),
+	}))
+	defer server.Close()
+	mailer := deps.Mailer().(*utils.MemoryMailer)
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	refreshToken := res.Result.(map[string]interface{})["refresh_token"].(string)
+	apiKey := newTestAPIKey(t, server, res.Result.(map[string]interface{})["token"].(string))
+	user, err := models.GetUserByUsername(deps.DB(), "testUsername")
+	require.NoError(t, err)
+
+	// nothing is sent until the user has a verified email
+	status, unknown := testutils.DoRequest(t, server, http.MethodPost, "/users/password/forgot", "", `{"username": "nobody"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, models.SetUserEmail(deps.DB(), user, "someone@example.com"))
+	status, unverified := testutils.DoRequest(t, server, http.MethodPost, "/users/password/forgot", "", `{"username": "testUsername"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, unknown, unverified)
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, mailer.Sent())
+
+	_, err = models.VerifyUserEmail(deps.DB(), user.ID, "someone@example.com")
+	require.NoError(t, err)
+	status, known := testutils.DoRequest(t, server, http.MethodPost, "/users/password/forgot", "", `{"username": "testUsername"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, unknown, known)
+
+	require.Eventually(t, func() bool { return len(mailer.Sent()) == 1 }, time.Second, 10*time.Millisecond)
+	mail := mailer.Sent()[0]
+	require.Equal(t, "someone@example.com", mail.To)
+	match := resetTokenPattern.FindStringSubmatch(mail.Body)
+	require.Len(t, match, 2)
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+
+	reset := func(token string) int {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/password/reset", "", fmt.Sprintf(`{"token": "%s", "new_password": "newPassword"}`, token))
+		return status
+	}
+	require.Equal(t, http.StatusForbidden, reset("notAToken"))
+	require.Equal(t, http.StatusOK, reset(token))
+	require.Equal(t, http.StatusForbidden, reset(token))
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "newPassword"))
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/token/refresh", "", fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken))
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", apiKey, "")
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewResetPasswordAPI(deps)}))
+	defer server.Close()
+
+	user, _ := testutils.NewUserWithToken(t, deps, "testUsername")
+	token, tokenHash, err := utils.NewSecretToken()
+	require.NoError(t, err)
+	_, err = models.NewPasswordReset(deps.DB(), &models.PasswordReset{UserID: user.ID, TokenHash: tokenHash, ExpiresAt: time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/password/reset", "", fmt.Sprintf(`{"token": "%s", "new_password": "newPassword"}`, token))
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewForgotPasswordAPI(deps)}))
+	defer server.Close()
+
+	forgot := func(username string) int {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/password/forgot", "", fmt.Sprintf(`{"username": "%s"}`, username))
+		return status
+	}
+	i := 0
+	for i < 5 {
+		require.Equal(t, http.StatusOK, forgot("someUsername"))
+		i++
+	}
+	// locked out regardless of case, and whether the user exists
+	require.Equal(t, http.StatusTooManyRequests, forgot("SOMEUSERNAME"))
+	require.Equal(t, http.StatusOK, forgot("someOtherUsername"))
+}
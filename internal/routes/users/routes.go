@@ -1,17 +1,29 @@
 package users
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
 	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/gofrs/uuid"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 const (
-	sessionDuration = time.Hour * 12
+	accessTokenDuration  = time.Minute * 15
+	refreshTokenDuration = time.Hour * 24 * 30
+
+	loginAttemptPrefix    = "login:"
+	loginIPAttemptPrefix  = "login-ip:"
+	passwordAttemptPrefix = "password:"
 )
 
 type SignupAPI struct {
@@ -21,21 +33,52 @@ type SignupAPI struct {
 type authInput struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"` // optional, signup only
 }
 
 type authResponse struct {
-	User  *models.User `json:"user"`
-	Token string       `json:"token"`
+	User         *models.User `json:"user"`
+	Token        string       `json:"token"`
+	ExpiresAt    int64        `json:"expires_at"` // unix seconds, refresh the token before then
+	RefreshToken string       `json:"refresh_token"`
 }
 
-func newAuthResponse(user *models.User) (res utils.Response, _ error) {
-	token, err := utils.NewJWT(user, sessionDuration)
+// newAuthResponse issues an access token and a refresh token continuing the
+// given session family, or starting a new one when familyID is empty.
+func newAuthResponse(db *gorm.DB, keys *utils.KeyRing, user *models.User, familyID string) (res utils.Response, _ error) {
+	if familyID == "" {
+		u, err := uuid.NewV4()
+		if err != nil {
+			return res, err
+		}
+		familyID = u.String()
+	}
+
+	token, err := utils.NewJWT(keys, user, familyID, accessTokenDuration)
+	if err != nil {
+		return res, err
+	}
+
+	refreshToken, refreshTokenHash, err := utils.NewSecretToken()
 	if err != nil {
 		return res, err
 	}
+
+	_, err = models.NewSession(db, &models.Session{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshTokenHash,
+		ExpiresAt: time.Now().Add(refreshTokenDuration),
+	})
+	if err != nil {
+		return res, err
+	}
+
 	return utils.NewSuccessResponse(authResponse{
-		User:  user,
-		Token: token,
+		User:         user,
+		Token:        token,
+		ExpiresAt:    time.Now().Add(accessTokenDuration).Unix(),
+		RefreshToken: refreshToken,
 	}), nil
 }
 
@@ -56,35 +99,44 @@ func (api *SignupAPI) Handler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
 	}
 
-	if !checkAuthInput(input) {
-		logger.Warn("missing parameters")
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	if details := checkSignupInput(api.deps.DB(), api.deps.Credentials(), input); len(details) > 0 {
+		logger.WithField("details", details).Warn("username or password rejected")
+		return c.JSON(http.StatusBadRequest, utils.NewFieldErrorResponse(details))
 	}
 
-	passwordHash, err := hashPassword(input.Password)
+	passwordHash, err := api.deps.Passwords().Hash(input.Password)
 	if err != nil {
 		logger.WithError(err).Error("could not hash password")
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
-	user, _ := models.GetUserByUsername(api.deps.DB(), input.Username)
-	if user != nil {
-		logger.Warn("user already exists")
-		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	var email *string
+	if input.Email != "" {
+		if err := checkEmail(api.deps.DB(), input.Email, 0); err != nil {
+			logger.WithError(err).Warn("invalid email")
+			return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+		}
+		email = &input.Email
 	}
 
 	db := api.deps.DB().Begin()
-	user, err = models.NewUser(db, &models.User{
+	user, err := models.NewUser(db, &models.User{
 		Username:     input.Username,
 		PasswordHash: passwordHash,
+		Email:        email,
 	})
+	if utils.IsUniqueViolation(err) {
+		db.Rollback()
+		logger.Warn("username or email already in use")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
 	if err != nil {
 		db.Rollback()
 		logger.WithError(err).Error("could not create user")
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
-	res, err := newAuthResponse(user)
+	res, err := newAuthResponse(db, api.deps.KeyRing(), user, "")
 	if err != nil {
 		db.Rollback()
 		logger.WithError(err).Error("could not build auth response")
@@ -96,6 +148,10 @@ func (api *SignupAPI) Handler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
+	if email != nil {
+		sendEmailVerification(api.deps, logger, user, *email)
+	}
+
 	logger.WithField("id", user.ID).Debug("user created")
 	return c.JSON(http.StatusOK, res)
 }
@@ -112,6 +168,7 @@ func (api *LoginAPI) Method() string                     { return http.MethodPos
 func (api *LoginAPI) Path() string                       { return "/users/login" }
 func (api *LoginAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
 
+// Handler answers users with 2FA with a challengeResponse instead of tokens.
 func (api *LoginAPI) Handler(c echo.Context) error {
 	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "LoginAPI")
 
@@ -126,40 +183,405 @@ func (api *LoginAPI) Handler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
 	}
 
+	attemptKey := loginAttemptKey(input.Username)
+	ipAttemptKey := loginIPAttemptPrefix + c.RealIP()
+	if ok, err := allowAttempt(c, logger, api.deps.Attempts(), attemptKey); !ok {
+		return err
+	}
+	if ok, err := allowAttempt(c, logger, api.deps.IPAttempts(), ipAttemptKey); !ok {
+		return err
+	}
+
 	user, err := models.GetUserByUsername(api.deps.DB(), input.Username)
 	if err != nil {
+		// hash anyway, or unknown usernames would answer faster than known ones
+		api.deps.Passwords().Verify(input.Password, "")
+		failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, nil)
+		failAttempt(api.deps, c, logger, api.deps.IPAttempts(), ipAttemptKey, nil)
 		logger.WithError(err).Warn("could not find user w/ username")
 		return c.JSON(http.StatusForbidden, utils.Response{Error: utils.InvalidAuthInfo})
 	}
 
-	if !checkHash(input.Password, user.PasswordHash) {
+	ok, rehash := api.deps.Passwords().Verify(input.Password, user.PasswordHash)
+	if !ok {
+		failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, &user.ID)
+		failAttempt(api.deps, c, logger, api.deps.IPAttempts(), ipAttemptKey, &user.ID)
 		logger.WithError(err).Warn("invalid password")
 		return c.JSON(http.StatusForbidden, utils.Response{Error: utils.InvalidAuthInfo})
 	}
+	// the IP is not reset, or one valid account would unlock guessing others
+	resetAttempts(logger, api.deps.Attempts(), attemptKey)
+	if rehash {
+		rehashPassword(api.deps, logger, user, input.Password)
+	}
+
+	if user.TOTPEnabled {
+		logger.WithField("id", user.ID).Debug("password accepted, 2fa required")
+		res, err := newChallengeResponse(api.deps.KeyRing(), user)
+		if err != nil {
+			logger.WithError(err).Error("could not build challenge response")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+		return c.JSON(http.StatusOK, res)
+	}
 
 	logger.WithField("id", user.ID).Debug("user logged in")
 
-	res, err := newAuthResponse(user)
+	res, err := newAuthResponse(api.deps.DB(), api.deps.KeyRing(), user, "")
+	if err != nil {
+		logger.WithError(err).Error("could not build auth response")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+type RefreshTokenAPI struct {
+	deps utils.Deps
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func NewRefreshTokenAPI(deps utils.Deps) utils.Route {
+	return &RefreshTokenAPI{deps}
+}
+
+func (api *RefreshTokenAPI) Method() string                     { return http.MethodPost }
+func (api *RefreshTokenAPI) Path() string                       { return "/users/token/refresh" }
+func (api *RefreshTokenAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler trades a refresh token for a new access and refresh token pair. Each
+// refresh token works once; replaying one revokes every session descended
+// from the same login.
+func (api *RefreshTokenAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "RefreshTokenAPI")
+
+	var input refreshInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.RefreshToken == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	session, err := models.GetSessionByTokenHash(api.deps.DB(), utils.HashSecretToken(input.RefreshToken))
+	if err != nil {
+		logger.WithError(err).Warn("could not find session w/ refresh token")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithFields(logrus.Fields{"session": session.ID, "family": session.FamilyID})
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		logger.Warn("session revoked or expired")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	db := api.deps.DB().Begin()
+	used, err := models.UseSession(db, session)
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not use session")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if !used {
+		if err := models.RevokeSessionFamily(db, session.FamilyID); err != nil {
+			db.Rollback()
+			logger.WithError(err).Error("could not revoke session family")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+		if err := db.Commit().Error; err != nil {
+			logger.WithError(err).Error("could not commit transaction for session family revocation")
+			return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+		}
+		logger.Warn("refresh token reused, revoked session family")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	user, err := models.GetUserByID(db, session.UserID)
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Warn("could not find session user")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	res, err := newAuthResponse(db, api.deps.KeyRing(), user, session.FamilyID)
+	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not build auth response")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for session refresh")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.WithField("id", user.ID).Debug("session refreshed")
+	return c.JSON(http.StatusOK, res)
+}
+
+type LogoutAPI struct {
+	deps utils.Deps
+}
+
+func NewLogoutAPI(deps utils.Deps) utils.Route {
+	return &LogoutAPI{deps}
+}
+
+func (api *LogoutAPI) Method() string { return http.MethodPost }
+func (api *LogoutAPI) Path() string   { return "/users/logout" }
+func (api *LogoutAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler revokes the access token it was called with and the refresh session
+// that token was issued for.
+func (api *LogoutAPI) Handler(c echo.Context) error {
+	return logout(api.deps, c, "LogoutAPI", false)
+}
+
+type LogoutAllAPI struct {
+	deps utils.Deps
+}
+
+func NewLogoutAllAPI(deps utils.Deps) utils.Route {
+	return &LogoutAllAPI{deps}
+}
+
+func (api *LogoutAllAPI) Method() string { return http.MethodPost }
+func (api *LogoutAllAPI) Path() string   { return "/users/logout-all" }
+func (api *LogoutAllAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler revokes every refresh session of the user, which also invalidates
+// all access tokens issued for them.
+func (api *LogoutAllAPI) Handler(c echo.Context) error {
+	return logout(api.deps, c, "LogoutAllAPI", true)
+}
+
+func logout(deps utils.Deps, c echo.Context, apiName string, all bool) error {
+	user := middlewares.RequireUser(c)
+	claims := middlewares.RequireClaims(c)
+	logger := deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": apiName, "user": user, "jti": claims.Id})
+
+	db := deps.DB().Begin()
+	if err := models.RevokeToken(db, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not revoke token")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	var err error
+	switch {
+	case all:
+		err = models.RevokeUserSessions(db, user.ID)
+	case claims.SessionID != "":
+		err = models.RevokeSessionFamily(db, claims.SessionID)
+	}
 	if err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not revoke sessions")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for logout")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("user logged out")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(user))
+}
+
+type ChangePasswordAPI struct {
+	deps utils.Deps
+}
+
+type changePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func NewChangePasswordAPI(deps utils.Deps) utils.Route {
+	return &ChangePasswordAPI{deps}
+}
+
+func (api *ChangePasswordAPI) Method() string { return http.MethodPost }
+func (api *ChangePasswordAPI) Path() string   { return "/users/password" }
+func (api *ChangePasswordAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler logs the user out everywhere, including the token it was called
+// with, and answers with a new session for the current device.
+func (api *ChangePasswordAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	claims := middlewares.RequireClaims(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "ChangePasswordAPI", "user": user})
+
+	var input changePasswordInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.CurrentPassword == "" || input.NewPassword == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if details := api.deps.Credentials().CheckPassword("new_password", input.NewPassword, user.Username); len(details) > 0 {
+		logger.WithField("details", details).Warn("new password rejected")
+		return c.JSON(http.StatusBadRequest, utils.NewFieldErrorResponse(details))
+	}
+
+	attemptKey := fmt.Sprintf("%s%d", passwordAttemptPrefix, user.ID)
+	if ok, err := allowAttempt(c, logger, api.deps.Attempts(), attemptKey); !ok {
+		return err
+	}
+
+	if ok, _ := api.deps.Passwords().Verify(input.CurrentPassword, user.PasswordHash); !ok {
+		failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, &user.ID)
+		logger.Warn("invalid current password")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	resetAttempts(logger, api.deps.Attempts(), attemptKey)
+
+	passwordHash, err := api.deps.Passwords().Hash(input.NewPassword)
+	if err != nil {
+		logger.WithError(err).Error("could not hash password")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	db := api.deps.DB().Begin()
+	if err := db.Model(user).Update("password_hash", passwordHash).Error; err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not update password")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.RevokeUserSessions(db, user.ID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not revoke sessions")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.DeleteUserAPIKeys(db, user.ID); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not delete api keys")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.RevokeToken(db, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not revoke token")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	res, err := newAuthResponse(db, api.deps.KeyRing(), user, "")
+	if err != nil {
+		db.Rollback()
 		logger.WithError(err).Error("could not build auth response")
 		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
 	}
 
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for password change")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("password changed")
 	return c.JSON(http.StatusOK, res)
 }
 
-func hashPassword(passwd string) (string, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+func tooManyAttempts(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return c.JSON(http.StatusTooManyRequests, utils.NewErrorResponse(utils.TooManyAttemptsMsg))
+}
+
+// allowAttempt reports whether key is not locked out. Otherwise it has already
+// answered, and the handler should return err.
+func allowAttempt(c echo.Context, logger *logrus.Entry, limiter *utils.AttemptLimiter, key string) (ok bool, err error) {
+	wait, err := limiter.Allow(key)
+	if err != nil {
+		logger.WithError(err).Error("could not check failed attempts")
+		return false, c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if wait > 0 {
+		logger.WithField("key", key).Warn("too many failed attempts")
+		return false, tooManyAttempts(c, wait)
+	}
+	return true, nil
+}
+
+// failAttempt records a failed attempt for key, and an audit event for the
+// lockout it may cause. Failing to do either does not change the response.
+func failAttempt(deps utils.Deps, c echo.Context, logger *logrus.Entry, limiter *utils.AttemptLimiter, key string, userID *uint) {
+	lockout, err := limiter.Fail(key)
+	if err != nil {
+		logger.WithError(err).Error("could not record failed attempt")
+		return
+	}
+	if lockout == 0 {
+		return
+	}
+
+	logger.WithFields(logrus.Fields{"key": key, "lockout": lockout}).Warn("locked out after too many failed attempts")
+	_, err = models.NewAuditEvent(deps.DB(), &models.AuditEvent{
+		Event:   models.AuditLockout,
+		UserID:  userID,
+		IP:      c.RealIP(),
+		Details: fmt.Sprintf("%s locked out for %v", key, lockout),
+	})
 	if err != nil {
-		return "", nil
+		logger.WithError(err).Error("could not record lockout audit event")
+	}
+}
+
+func resetAttempts(logger *logrus.Entry, limiter *utils.AttemptLimiter, key string) {
+	if err := limiter.Reset(key); err != nil {
+		logger.WithError(err).Error("could not reset failed attempts")
 	}
-	return string(b), nil
 }
 
-func checkHash(passwd string, hash string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+// rehashPassword upgrades the hash of a password that was just verified to
+// the preferred hasher and cost. The login goes ahead if it fails.
+func rehashPassword(deps utils.Deps, logger *logrus.Entry, user *models.User, password string) {
+	passwordHash, err := deps.Passwords().Hash(password)
+	if err != nil {
+		logger.WithError(err).Error("could not rehash password")
+		return
+	}
+	if err := deps.DB().Model(user).Update("password_hash", passwordHash).Error; err != nil {
+		logger.WithError(err).Error("could not update rehashed password")
+		return
+	}
+	logger.WithField("id", user.ID).Debug("password rehashed")
 }
 
 func checkAuthInput(input authInput) bool {
 	return input.Username != "" && input.Password != ""
 }
+
+// checkSignupInput returns every rule the new username and password break,
+// including the username being taken.
+func checkSignupInput(db *gorm.DB, policy *utils.CredentialPolicy, input authInput) []utils.FieldError {
+	details := policy.CheckUsername(input.Username)
+	if _, err := models.GetUserByUsername(db, input.Username); err == nil {
+		details = append(details, utils.FieldError{Field: "username", Rule: utils.RuleTaken, Message: "is already taken"})
+	}
+	return append(details, policy.CheckPassword("password", input.Password, input.Username)...)
+}
+
+// loginAttemptKey ignores case like logins do, or changing it would get
+// around the lockout.
+func loginAttemptKey(username string) string {
+	return loginAttemptPrefix + strings.ToLower(username)
+}
@@ -1,7 +1,13 @@
 package users
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,12 +16,17 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/channels"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
 	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
 	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestSignupAPIHappyPath(t *testing.T) {
@@ -47,9 +58,10 @@ func TestSignupAPIHappyPath(t *testing.T) {
 	user, err := models.GetUserByUsername(deps.DB(), username)
 	require.NoError(t, err)
 	require.Equal(t, username, user.Username)
-	require.True(t, checkHash(password, user.PasswordHash))
+	ok, _ := deps.Passwords().Verify(password, user.PasswordHash)
+	require.True(t, ok)
 
-	compareUser, err := utils.ValidateJWT(deps.DB(), token)
+	compareUser, err := utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
 	require.NoError(t, err)
 	require.Equal(t, user.ID, compareUser.ID)
 	require.Equal(t, user.Username, compareUser.Username)
@@ -103,7 +115,8 @@ func TestSignupAlreadyExists(t *testing.T) {
 	user, err := models.GetUserByUsername(deps.DB(), username)
 	require.NoError(t, err)
 	require.Equal(t, username, user.Username)
-	require.True(t, checkHash(password, user.PasswordHash))
+	ok, _ := deps.Passwords().Verify(password, user.PasswordHash)
+	require.True(t, ok)
 
 	w = httptest.NewRecorder()
 	c = echo.New().NewContext(r, w)
@@ -111,6 +124,43 @@ func TestSignupAlreadyExists(t *testing.T) {
 	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
 }
 
+func TestSignupPolicy(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewSignupAPI(deps), NewLoginAPI(deps)}))
+	defer server.Close()
+
+	rules := func(username, password string) []string {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody(username, password))
+		require.Equal(t, http.StatusBadRequest, status)
+		require.Equal(t, utils.BadRequestMsg, res.Error)
+		rules := []string{}
+		for _, detail := range res.Details {
+			rules = append(rules, detail.Field+":"+detail.Rule)
+		}
+		return rules
+	}
+	require.Equal(t, []string{"username:min_length", "password:min_length"}, rules("a", "Bc1"))
+	require.Equal(t, []string{"username:charset"}, rules("some user", "testPassword"))
+	require.Equal(t, []string{"username:charset"}, rules("someUser🙂", "testPassword"))
+	require.Equal(t, []string{"username:reserved"}, rules("Admin", "testPassword"))
+	require.Equal(t, []string{"password:character_classes"}, rules("testUsername", "lowercaseonly"))
+	require.Equal(t, []string{"password:common_password"}, rules("testUsername", "Password1"))
+	require.Equal(t, []string{"password:contains_username"}, rules("testUsername", "testUsername!"))
+	require.Equal(t, []string{"password:max_length"}, rules("testUsername", "aA"+strings.Repeat("b", 63)))
+
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []string{"username:taken"}, rules("TESTUSERNAME", "testPassword"))
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("TestUserName", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+
+	// the database enforces it too
+	_, err = models.NewUser(deps.DB(), &models.User{Username: "testusername"})
+	require.Error(t, err)
+}
+
 func TestLoginAPIHappyPath(t *testing.T) {
 	deps, fileName, err := testutils.NewUnitDeps()
 	require.NoError(t, err)
@@ -134,7 +184,8 @@ func TestLoginAPIHappyPath(t *testing.T) {
 	user, err := models.GetUserByUsername(deps.DB(), username)
 	require.NoError(t, err)
 	require.Equal(t, username, user.Username)
-	require.True(t, checkHash(password, user.PasswordHash))
+	ok, _ := deps.Passwords().Verify(password, user.PasswordHash)
+	require.True(t, ok)
 
 	body = createAuthInput(username, password)
 	r, err = http.NewRequest(http.MethodPost, "/users/login", body)
@@ -169,7 +220,8 @@ func TestLoginAPIInvalidPassword(t *testing.T) {
 	user, err := models.GetUserByUsername(deps.DB(), username)
 	require.NoError(t, err)
 	require.Equal(t, username, user.Username)
-	require.True(t, checkHash(password, user.PasswordHash))
+	ok, _ := deps.Passwords().Verify(password, user.PasswordHash)
+	require.True(t, ok)
 
 	body = createAuthInput(username, "badPassword")
 	r, err = http.NewRequest(http.MethodPost, "/users/login", body)
@@ -181,6 +233,407 @@ func TestLoginAPIInvalidPassword(t *testing.T) {
 	require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
 }
 
+// countingHasher counts the passwords it verifies.
+type countingHasher struct {
+	utils.PasswordHasher
+	verified int
+}
+
+func (hasher *countingHasher) Verify(password, hash string) bool {
+	hasher.verified++
+	return hasher.PasswordHasher.Verify(password, hash)
+}
+
+// passwordsDeps swaps the unit hashers for counting ones.
+type passwordsDeps struct {
+	utils.Deps
+	passwords *utils.PasswordHashers
+}
+
+func (deps *passwordsDeps) Passwords() *utils.PasswordHashers { return deps.passwords }
+
+func TestLoginAPIHashesWithoutPasswordHash(t *testing.T) {
+	unitDeps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	hasher := &countingHasher{PasswordHasher: &utils.Argon2idHasher{Memory: 1024, Time: 1, Threads: 1}}
+	deps := &passwordsDeps{unitDeps, utils.NewPasswordHashers(hasher)}
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewLoginAPI(deps)}))
+	defer server.Close()
+
+	// neither unknown usernames nor users without a password answer faster
+	_, err = models.NewUser(deps.DB(), &models.User{Username: "oidcUsername"})
+	require.NoError(t, err)
+	for _, username := range []string{"unknownUsername", "oidcUsername"} {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody(username, "testPassword"))
+		require.Equal(t, http.StatusForbidden, status)
+	}
+	require.Equal(t, 2, hasher.verified)
+}
+
+func TestLoginAPIRehashesPassword(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewLoginAPI(deps)}))
+	defer server.Close()
+
+	bcryptHash, err := (&utils.BcryptHasher{Cost: bcrypt.MinCost}).Hash("testPassword")
+	require.NoError(t, err)
+	weakHash, err := (&utils.Argon2idHasher{Memory: 512, Time: 1, Threads: 1}).Hash("testPassword")
+	require.NoError(t, err)
+	for username, passwordHash := range map[string]string{"bcryptUsername": bcryptHash, "weakUsername": weakHash} {
+		user, err := models.NewUser(deps.DB(), &models.User{Username: username, PasswordHash: passwordHash})
+		require.NoError(t, err)
+
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody(username, "testPassword"))
+		require.Equal(t, http.StatusOK, status)
+		user, err = models.GetUserByID(deps.DB(), user.ID)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(user.PasswordHash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+		ok, rehash := deps.Passwords().Verify("testPassword", user.PasswordHash)
+		require.True(t, ok)
+		require.False(t, rehash)
+
+		status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody(username, "testPassword"))
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	// users who only sign in with OIDC have no password
+	_, err = models.NewUser(deps.DB(), &models.User{Username: "oidcUsername"})
+	require.NoError(t, err)
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("oidcUsername", ""))
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("oidcUsername", "testPassword"))
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestRefreshTokenAPI(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewSignupAPI(deps), NewRefreshTokenAPI(deps)}))
+	defer server.Close()
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", `{"username": "testUsername", "password": "testPassword"}`)
+	require.Equal(t, http.StatusOK, status)
+	first := res.Result.(map[string]interface{})["refresh_token"].(string)
+	require.NotEmpty(t, first)
+
+	refresh := func(refreshToken string) (int, map[string]interface{}) {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/token/refresh", "", fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken))
+		result, _ := res.Result.(map[string]interface{})
+		return status, result
+	}
+
+	status, result := refresh(first)
+	require.Equal(t, http.StatusOK, status)
+	second := result["refresh_token"].(string)
+	require.NotEqual(t, first, second)
+	user, err := utils.ValidateJWT(deps.DB(), deps.KeyRing(), result["token"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "testUsername", user.Username)
+
+	status, result = refresh(second)
+	require.Equal(t, http.StatusOK, status)
+	third := result["refresh_token"].(string)
+
+	// replaying a used token revokes the rest of its family too
+	status, _ = refresh(first)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = refresh(third)
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, _ = refresh("notARefreshToken")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = refresh("")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestRefreshTokenAPIExpired(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewRefreshTokenAPI(deps)}))
+	defer server.Close()
+
+	user, _ := testutils.NewUserWithToken(t, deps, "testUsername")
+	refreshToken, refreshTokenHash, err := utils.NewSecretToken()
+	require.NoError(t, err)
+	_, err = models.NewSession(deps.DB(), &models.Session{
+		UserID:    user.ID,
+		FamilyID:  "someFamily",
+		TokenHash: refreshTokenHash,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/token/refresh", "", fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken))
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestLogoutAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSignupAPI(deps),
+		NewLoginAPI(deps),
+		NewRefreshTokenAPI(deps),
+		NewLogoutAPI(deps),
+		NewLogoutAllAPI(deps),
+	}))
+	defer server.Close()
+
+	const body = `{"username": "testUsername", "password": "testPassword"}`
+	status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users", "", body)
+	require.Equal(t, http.StatusOK, status)
+
+	login := func() (string, string) {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", body)
+		require.Equal(t, http.StatusOK, status)
+		result := res.Result.(map[string]interface{})
+		return result["token"].(string), result["refresh_token"].(string)
+	}
+	refresh := func(refreshToken string) int {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/token/refresh", "", fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken))
+		return status
+	}
+
+	phoneToken, phoneRefreshToken := login()
+	laptopToken, laptopRefreshToken := login()
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/logout", phoneToken, "")
+	require.Equal(t, http.StatusOK, status)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), phoneToken)
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, refresh(phoneRefreshToken))
+
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), laptopToken)
+	require.NoError(t, err)
+	tabletToken, tabletRefreshToken := login()
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/logout-all", laptopToken, "")
+	require.Equal(t, http.StatusOK, status)
+	for _, token := range []string{laptopToken, tabletToken} {
+		_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+		require.Error(t, err)
+	}
+	require.Equal(t, http.StatusForbidden, refresh(laptopRefreshToken))
+	require.Equal(t, http.StatusForbidden, refresh(tabletRefreshToken))
+
+	token, _ := login()
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+	require.NoError(t, err)
+}
+
+func TestChangePasswordAPI(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSignupAPI(deps),
+		NewLoginAPI(deps),
+		NewRefreshTokenAPI(deps),
+		NewChangePasswordAPI(deps),
+		NewCreateAPIKeyAPI(deps),
+		channels.NewGetChannelsAPI(deps),
+	}))
+	defer server.Close()
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	token := res.Result.(map[string]interface{})["token"].(string)
+	apiKey := newTestAPIKey(t, server, token)
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	otherToken := res.Result.(map[string]interface{})["token"].(string)
+	otherRefreshToken := res.Result.(map[string]interface{})["refresh_token"].(string)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/password", token, `{"current_password": "badPassword", "new_password": "newPassword"}`)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/password", token, `{"current_password": "testPassword"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/password", token, `{"current_password": "testPassword", "new_password": "password123"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, []utils.FieldError{{Field: "new_password", Rule: utils.RuleCommonPassword, Message: "is too common"}}, res.Details)
+
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/password", token, `{"current_password": "testPassword", "new_password": "newPassword"}`)
+	require.Equal(t, http.StatusOK, status)
+	newToken := res.Result.(map[string]interface{})["token"].(string)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), newToken)
+	require.NoError(t, err)
+
+	for _, oldToken := range []string{token, otherToken} {
+		_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), oldToken)
+		require.Error(t, err)
+	}
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/token/refresh", "", fmt.Sprintf(`{"refresh_token": "%s"}`, otherRefreshToken))
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoBearerRequest(t, server, http.MethodGet, "/channels", apiKey, "")
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "newPassword"))
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestFailedAttemptsAreRateLimited(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{NewSignupAPI(deps), NewLoginAPI(deps), NewChangePasswordAPI(deps)}))
+	defer server.Close()
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	token := res.Result.(map[string]interface{})["token"].(string)
+
+	for _, route := range []struct{ path, token, badBody, goodBody string }{
+		{"/users/login", "", createAuthBody("testUsername", "badPassword"), createAuthBody("testUsername", "testPassword")},
+		{"/users/password", token, `{"current_password": "badPassword", "new_password": "newPassword"}`, `{"current_password": "testPassword", "new_password": "newPassword"}`},
+	} {
+		i := 0
+		for i < 5 {
+			status, _ = testutils.DoRequest(t, server, http.MethodPost, route.path, route.token, route.badBody)
+			require.Equal(t, http.StatusForbidden, status, route.path)
+			i++
+		}
+
+		// even the right password is refused until the window passes
+		r, err := http.NewRequest(http.MethodPost, server.URL+route.path, strings.NewReader(route.goodBody))
+		require.NoError(t, err)
+		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		r.Header.Set(middlewares.JwtRequestHeader, route.token)
+		w, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		w.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, w.StatusCode, route.path)
+		require.NotEmpty(t, w.Header.Get("Retry-After"))
+	}
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("someOtherUsername", "testPassword"))
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestRevokeTokenPrunesExpired(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+
+	require.NoError(t, models.RevokeToken(deps.DB(), "someExpiredJTI", time.Now().Add(-time.Minute)))
+	require.NoError(t, models.RevokeToken(deps.DB(), "someJTI", time.Now().Add(time.Minute)))
+	require.NoError(t, models.RevokeToken(deps.DB(), "someJTI", time.Now().Add(time.Minute)))
+
+	tokens := []models.RevokedToken{}
+	require.NoError(t, deps.DB().Find(&tokens).Error)
+	require.Len(t, tokens, 1)
+	require.Equal(t, "someJTI", tokens[0].JTI)
+}
+
+func TestKeyRingAlgorithms(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	user, _ := testutils.NewUserWithToken(t, deps, "testUsername")
+
+	for _, config := range []utils.KeyConfig{newKeyConfig(t, "HS256"), newKeyConfig(t, "RS256"), newKeyConfig(t, "EdDSA")} {
+		keys, err := utils.NewKeyRing([]utils.KeyConfig{config}, "")
+		require.NoError(t, err, config.Algorithm)
+		token, err := utils.NewJWT(keys, user, "", time.Minute)
+		require.NoError(t, err, config.Algorithm)
+		compareUser, err := utils.ValidateJWT(deps.DB(), keys, token)
+		require.NoError(t, err, config.Algorithm)
+		require.Equal(t, user.ID, compareUser.ID)
+
+		_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), token)
+		require.Error(t, err, config.Algorithm)
+	}
+
+	_, err = utils.NewKeyRing([]utils.KeyConfig{{ID: "someKey", Algorithm: "none"}}, "")
+	require.Error(t, err)
+	_, err = utils.NewKeyRing([]utils.KeyConfig{newKeyConfig(t, "HS256")}, "unknownKey")
+	require.Error(t, err)
+	compromised := newKeyConfig(t, "EdDSA")
+	compromised.ID = "dev-2021-10" // was committed to the repo, whatever key it names now
+	_, err = utils.NewKeyRing([]utils.KeyConfig{compromised}, "")
+	require.Error(t, err)
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	user, _ := testutils.NewUserWithToken(t, deps, "testUsername")
+
+	oldKey, newKey := newKeyConfig(t, "EdDSA"), newKeyConfig(t, "RS256")
+	oldKey.ID, newKey.ID = "old", "new"
+	before, err := utils.NewKeyRing([]utils.KeyConfig{oldKey}, "")
+	require.NoError(t, err)
+	during, err := utils.NewKeyRing([]utils.KeyConfig{oldKey, newKey}, "new")
+	require.NoError(t, err)
+	after, err := utils.NewKeyRing([]utils.KeyConfig{newKey}, "")
+	require.NoError(t, err)
+
+	oldToken, err := utils.NewJWT(before, user, "", time.Minute)
+	require.NoError(t, err)
+	newToken, err := utils.NewJWT(during, user, "", time.Minute)
+	require.NoError(t, err)
+
+	for _, token := range []string{oldToken, newToken} {
+		_, err = utils.ValidateJWT(deps.DB(), during, token)
+		require.NoError(t, err)
+	}
+	_, err = utils.ValidateJWT(deps.DB(), after, newToken)
+	require.NoError(t, err)
+	_, err = utils.ValidateJWT(deps.DB(), after, oldToken)
+	require.Error(t, err)
+}
+
+// newKeyConfig generates a fresh key for the algorithm, keyed by its name.
+func newKeyConfig(t *testing.T, alg string) utils.KeyConfig {
+	config := utils.KeyConfig{ID: alg, Algorithm: alg}
+	var privateKey interface{}
+	switch alg {
+	case "HS256":
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		require.NoError(t, err)
+		config.Key = base64.StdEncoding.EncodeToString(secret)
+		return config
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		privateKey = key
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		privateKey = key
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	config.Key = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return config
+}
+
 func createAuthInput(username, password string) io.Reader {
-	return strings.NewReader(fmt.Sprintf(`{"username": "%s", "password": "%s"}`, username, password))
+	return strings.NewReader(createAuthBody(username, password))
+}
+
+func createAuthBody(username, password string) string {
+	return fmt.Sprintf(`{"username": "%s", "password": "%s"}`, username, password)
+}
+
+func TestProdKeyRingOnlyGeneratesKeysInDevelopment(t *testing.T) {
+	os.Unsetenv("JWT_KEYS")
+	os.Unsetenv("APP_ENV")
+	_, err := utils.NewProdKeyRing(logrus.New())
+	require.Error(t, err)
+
+	os.Setenv("APP_ENV", "development")
+	defer os.Unsetenv("APP_ENV")
+	ring, err := utils.NewProdKeyRing(logrus.New())
+	require.NoError(t, err)
+	require.Len(t, ring.JWKs(), 1)
 }
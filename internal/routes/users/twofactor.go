@@ -0,0 +1,291 @@
+package users
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	twoFactorChallengeDuration = time.Minute * 5
+	twoFactorAttemptPrefix     = "2fa:"
+	totpQRCodeSize             = 256
+)
+
+type twoFactorSetupResponse struct {
+	Secret string `json:"secret"`  // for typing into apps that cannot scan the QR code
+	URI    string `json:"uri"`     // otpauth:// URI the QR code encodes
+	QRCode string `json:"qr_code"` // base64 PNG
+}
+
+type twoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown only once
+}
+
+// challengeResponse is what LoginAPI answers instead of an authResponse for
+// users with 2FA, the challenge token and a code are traded for tokens at
+// TwoFactorLoginAPI.
+type challengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresAt      int64  `json:"expires_at"` // unix seconds
+}
+
+type twoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"` // a TOTP or a recovery code
+}
+
+type TwoFactorSetupAPI struct {
+	deps utils.Deps
+}
+
+func NewTwoFactorSetupAPI(deps utils.Deps) utils.Route {
+	return &TwoFactorSetupAPI{deps}
+}
+
+func (api *TwoFactorSetupAPI) Method() string { return http.MethodPost }
+func (api *TwoFactorSetupAPI) Path() string   { return "/users/2fa/setup" }
+func (api *TwoFactorSetupAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler starts over with a new secret every time, 2FA is only enabled once
+// a code for it is confirmed.
+func (api *TwoFactorSetupAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "TwoFactorSetupAPI", "user": user})
+
+	if user.TOTPEnabled {
+		logger.Warn("2fa already enabled")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	key, err := utils.NewTOTPKey(user.Username)
+	if err != nil {
+		logger.WithError(err).Error("could not generate totp key")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	img, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		logger.WithError(err).Error("could not render qr code")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	var qrCode bytes.Buffer
+	if err := png.Encode(&qrCode, img); err != nil {
+		logger.WithError(err).Error("could not encode qr code")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	sealedSecret, err := api.deps.Secrets().Seal(key.Secret(), totpSecretContext(user.ID))
+	if err != nil {
+		logger.WithError(err).Error("could not seal totp secret")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := models.SetUserTOTPSecret(api.deps.DB(), user, sealedSecret); err != nil {
+		logger.WithError(err).Error("could not store totp secret")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("2fa setup started")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(twoFactorSetupResponse{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: base64.StdEncoding.EncodeToString(qrCode.Bytes()),
+	}))
+}
+
+type TwoFactorConfirmAPI struct {
+	deps utils.Deps
+}
+
+func NewTwoFactorConfirmAPI(deps utils.Deps) utils.Route {
+	return &TwoFactorConfirmAPI{deps}
+}
+
+func (api *TwoFactorConfirmAPI) Method() string { return http.MethodPost }
+func (api *TwoFactorConfirmAPI) Path() string   { return "/users/2fa/confirm" }
+func (api *TwoFactorConfirmAPI) Middlewares() []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{middlewares.UserAuthMiddleware(api.deps)}
+}
+
+// Handler enables 2FA once the user shows their authenticator works, and
+// hands out the recovery codes.
+func (api *TwoFactorConfirmAPI) Handler(c echo.Context) error {
+	user := middlewares.RequireUser(c)
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithFields(logrus.Fields{"api": "TwoFactorConfirmAPI", "user": user})
+
+	var input twoFactorCodeInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.Code == "" || user.TOTPSecret == nil || user.TOTPEnabled {
+		logger.Warn("missing parameters or no 2fa setup in progress")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	attemptKey := fmt.Sprintf("%s%d", twoFactorAttemptPrefix, user.ID)
+	if ok, err := allowAttempt(c, logger, api.deps.Attempts(), attemptKey); !ok {
+		return err
+	}
+
+	ok, err := checkTOTP(api.deps, api.deps.DB(), user, input.Code)
+	if err != nil {
+		logger.WithError(err).Error("could not check totp code")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if !ok {
+		failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, &user.ID)
+		logger.Warn("invalid totp code")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	resetAttempts(logger, api.deps.Attempts(), attemptKey)
+
+	codes, codeHashes, err := utils.NewRecoveryCodes()
+	if err != nil {
+		logger.WithError(err).Error("could not generate recovery codes")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	db := api.deps.DB().Begin()
+	if err := models.EnableUserTOTP(db, user, codeHashes); err != nil {
+		db.Rollback()
+		logger.WithError(err).Error("could not enable 2fa")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	if err := db.Commit().Error; err != nil {
+		logger.WithError(err).Error("could not commit transaction for enabling 2fa")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	logger.Debug("2fa enabled")
+	return c.JSON(http.StatusOK, utils.NewSuccessResponse(recoveryCodesResponse{RecoveryCodes: codes}))
+}
+
+type TwoFactorLoginAPI struct {
+	deps utils.Deps
+}
+
+func NewTwoFactorLoginAPI(deps utils.Deps) utils.Route {
+	return &TwoFactorLoginAPI{deps}
+}
+
+func (api *TwoFactorLoginAPI) Method() string                     { return http.MethodPost }
+func (api *TwoFactorLoginAPI) Path() string                       { return "/users/2fa/login" }
+func (api *TwoFactorLoginAPI) Middlewares() []echo.MiddlewareFunc { return []echo.MiddlewareFunc{} }
+
+// Handler is the second step of logging in with 2FA, it accepts a recovery
+// code in place of a TOTP code.
+func (api *TwoFactorLoginAPI) Handler(c echo.Context) error {
+	logger := api.deps.Logger().WithContext(c.Request().Context()).WithField("api", "TwoFactorLoginAPI")
+
+	var input twoFactorLoginInput
+	if err := c.Bind(&input); err != nil {
+		logger.WithError(err).Warn(utils.BadRequestMsg)
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	if input.ChallengeToken == "" || input.Code == "" {
+		logger.Warn("missing parameters")
+		return c.JSON(http.StatusBadRequest, utils.NewErrorResponse(utils.BadRequestMsg))
+	}
+
+	userID, err := utils.ValidateTwoFactorChallengeJWT(api.deps.KeyRing(), input.ChallengeToken)
+	if err != nil {
+		logger.WithError(err).Warn("invalid challenge token")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	logger = logger.WithField("user", userID)
+
+	attemptKey := fmt.Sprintf("%s%d", twoFactorAttemptPrefix, userID)
+	if ok, err := allowAttempt(c, logger, api.deps.Attempts(), attemptKey); !ok {
+		return err
+	}
+
+	user, err := models.GetUserByID(api.deps.DB(), userID)
+	if err != nil || !user.TOTPEnabled {
+		logger.WithError(err).Warn("could not find user w/ 2fa")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+
+	ok, err := checkTOTP(api.deps, api.deps.DB(), user, input.Code)
+	if err == nil && !ok {
+		ok, err = models.UseRecoveryCode(api.deps.DB(), user.ID, utils.HashRecoveryCode(input.Code))
+		if ok {
+			logger.Info("recovery code used")
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("could not check 2fa code")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+	if !ok {
+		failAttempt(api.deps, c, logger, api.deps.Attempts(), attemptKey, &user.ID)
+		logger.Warn("invalid 2fa code")
+		return c.JSON(http.StatusForbidden, utils.NewErrorResponse(utils.InvalidAuthInfo))
+	}
+	resetAttempts(logger, api.deps.Attempts(), attemptKey)
+
+	logger.Debug("user logged in w/ 2fa")
+
+	res, err := newAuthResponse(api.deps.DB(), api.deps.KeyRing(), user, "")
+	if err != nil {
+		logger.WithError(err).Error("could not build auth response")
+		return c.JSON(http.StatusInternalServerError, utils.NewErrorResponse(utils.InternalServerError))
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// newChallengeResponse starts the second step of a login with 2FA.
+func newChallengeResponse(keys *utils.KeyRing, user *models.User) (res utils.Response, _ error) {
+	token, err := utils.NewTwoFactorChallengeJWT(keys, user, twoFactorChallengeDuration)
+	if err != nil {
+		return res, err
+	}
+	return utils.NewSuccessResponse(challengeResponse{
+		ChallengeToken: token,
+		ExpiresAt:      time.Now().Add(twoFactorChallengeDuration).Unix(),
+	}), nil
+}
+
+// checkTOTP reports whether code is valid for the user's TOTP secret and was
+// not used before.
+func checkTOTP(deps utils.Deps, db *gorm.DB, user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+	secret, err := deps.Secrets().Open(*user.TOTPSecret, totpSecretContext(user.ID))
+	if err != nil {
+		return false, err
+	}
+	step := utils.ValidateTOTP(secret, code, time.Now())
+	if step == 0 {
+		return false, nil
+	}
+	return models.UseTOTPStep(db, user.ID, step)
+}
+
+// totpSecretContext ties a sealed TOTP secret to its user, so it cannot be
+// copied over to another account.
+func totpSecretContext(userID uint) string {
+	return fmt.Sprintf("totp:%d", userID)
+}
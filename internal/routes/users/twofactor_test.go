@@ -0,0 +1,121 @@
+package users
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/testutils"
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactorAPIs(t *testing.T) {
+	deps, fileName, err := testutils.NewUnitDeps()
+	require.NoError(t, err)
+	defer os.Remove(fileName)
+	server := httptest.NewServer(utils.NewServer([]utils.Route{
+		NewSignupAPI(deps),
+		NewLoginAPI(deps),
+		NewTwoFactorSetupAPI(deps),
+		NewTwoFactorConfirmAPI(deps),
+		NewTwoFactorLoginAPI(deps),
+	}))
+	defer server.Close()
+
+	status, res := testutils.DoRequest(t, server, http.MethodPost, "/users", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	token := res.Result.(map[string]interface{})["token"].(string)
+
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/setup", token, "")
+	require.Equal(t, http.StatusOK, status)
+	setup := res.Result.(map[string]interface{})
+	secret := setup["secret"].(string)
+	require.True(t, strings.HasPrefix(setup["uri"].(string), "otpauth://totp/Nimble:testUsername?"))
+	qrCode, err := base64.StdEncoding.DecodeString(setup["qr_code"].(string))
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(qrCode))
+	require.NoError(t, err)
+
+	user, err := models.GetUserByUsername(deps.DB(), "testUsername")
+	require.NoError(t, err)
+	require.False(t, user.TOTPEnabled)
+	require.NotContains(t, *user.TOTPSecret, secret) // stored sealed
+
+	// 2fa is not required before it is confirmed
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "testPassword"))
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, res.Result.(map[string]interface{})["token"])
+
+	now := time.Now()
+	code := newTOTPCode(t, secret, now)
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/confirm", token, `{"code": "000000"}`)
+	require.Equal(t, http.StatusForbidden, status)
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/confirm", token, fmt.Sprintf(`{"code": "%s"}`, code))
+	require.Equal(t, http.StatusOK, status)
+	recoveryCodes := res.Result.(map[string]interface{})["recovery_codes"].([]interface{})
+	require.Len(t, recoveryCodes, 10)
+
+	status, _ = testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/setup", token, "")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	login := func() string {
+		status, res := testutils.DoRequest(t, server, http.MethodPost, "/users/login", "", createAuthBody("testUsername", "testPassword"))
+		require.Equal(t, http.StatusOK, status)
+		result := res.Result.(map[string]interface{})
+		require.Nil(t, result["token"])
+		return result["challenge_token"].(string)
+	}
+	loginWithCode := func(challengeToken, code string) int {
+		status, _ := testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/login", "", fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, code))
+		return status
+	}
+
+	challengeToken := login()
+	require.Equal(t, http.StatusForbidden, loginWithCode(token, code))          // access tokens are not challenge tokens
+	require.Equal(t, http.StatusForbidden, loginWithCode(challengeToken, code)) // codes cannot be replayed
+	status, res = testutils.DoRequest(t, server, http.MethodPost, "/users/2fa/login", "", fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, newTOTPCode(t, secret, now.Add(30*time.Second))))
+	require.Equal(t, http.StatusOK, status)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), res.Result.(map[string]interface{})["token"].(string))
+	require.NoError(t, err)
+	_, err = utils.ValidateJWT(deps.DB(), deps.KeyRing(), challengeToken)
+	require.Error(t, err) // and challenge tokens are not access tokens
+
+	recoveryCode := recoveryCodes[0].(string)
+	require.Equal(t, http.StatusOK, loginWithCode(login(), strings.ToUpper(recoveryCode)))
+	require.Equal(t, http.StatusForbidden, loginWithCode(login(), recoveryCode))
+	require.Equal(t, http.StatusOK, loginWithCode(login(), recoveryCodes[1].(string)))
+}
+
+func newTOTPCode(t *testing.T, secret string, at time.Time) string {
+	code, err := totp.GenerateCode(secret, at)
+	require.NoError(t, err)
+	return code
+}
+
+func TestProdSecretBoxRequiresKeyOutsideDevelopment(t *testing.T) {
+	os.Unsetenv("SECRETS_KEY")
+	os.Unsetenv("APP_ENV")
+	_, err := utils.NewProdSecretBox(logrus.New())
+	require.Error(t, err)
+
+	os.Setenv("APP_ENV", "development")
+	defer os.Unsetenv("APP_ENV")
+	box, err := utils.NewProdSecretBox(logrus.New())
+	require.NoError(t, err)
+	sealed, err := box.Seal("someSecret", "someContext")
+	require.NoError(t, err)
+	opened, err := box.Open(sealed, "someContext")
+	require.NoError(t, err)
+	require.Equal(t, "someSecret", opened)
+}
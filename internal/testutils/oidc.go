@@ -0,0 +1,185 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mockOIDCClientID     = "someClientID"
+	mockOIDCClientSecret = "someClientSecret"
+	mockOIDCRedirectURL  = "nimble://oidc/callback"
+)
+
+// MockOIDCUser is who signs in at the mock provider.
+type MockOIDCUser struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// MockOIDCProvider is a local OIDC provider for tests. Its authorization
+// endpoint signs User in without asking and redirects straight back.
+type MockOIDCProvider struct {
+	*httptest.Server
+	User MockOIDCUser
+
+	keys           *utils.KeyRing
+	mutex          sync.Mutex
+	authorizations map[string]mockAuthorization // by code
+}
+
+type mockAuthorization struct {
+	query url.Values
+	user  MockOIDCUser
+}
+
+func NewMockOIDCProvider(t *testing.T) *MockOIDCProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	keys, err := utils.NewKeyRing([]utils.KeyConfig{{
+		ID:        "mock",
+		Algorithm: jwt.SigningMethodRS256.Alg(),
+		Key:       string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}}, "mock")
+	require.NoError(t, err)
+
+	provider := &MockOIDCProvider{keys: keys, authorizations: map[string]mockAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/authorize", provider.authorize)
+	mux.HandleFunc("/token", provider.token)
+	mux.HandleFunc("/jwks", provider.jwks)
+	provider.Server = httptest.NewServer(mux)
+	return provider
+}
+
+// Config registers the provider under name with the backend.
+func (provider *MockOIDCProvider) Config(name string) utils.OIDCProviderConfig {
+	return utils.OIDCProviderConfig{
+		Name:         name,
+		Issuer:       provider.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		RedirectURL:  mockOIDCRedirectURL,
+	}
+}
+
+// Authorize follows an authorization URL like a browser would and returns
+// the code and state the provider redirected back with.
+func (provider *MockOIDCProvider) Authorize(t *testing.T, authorizationURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authorizationURL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (provider *MockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                provider.URL,
+		"authorization_endpoint":                provider.URL + "/authorize",
+		"token_endpoint":                        provider.URL + "/token",
+		"jwks_uri":                              provider.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodRS256.Alg()},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (provider *MockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != mockOIDCClientID || query.Get("redirect_uri") != mockOIDCRedirectURL || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _, err := utils.NewSecretToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	provider.mutex.Lock()
+	provider.authorizations[code] = mockAuthorization{query, provider.User}
+	provider.mutex.Unlock()
+	http.Redirect(w, r, mockOIDCRedirectURL+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+}
+
+// token only accepts each code once, from the client it was issued to and
+// with the verifier of its PKCE challenge.
+func (provider *MockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	provider.mutex.Lock()
+	authorization, ok := provider.authorizations[r.PostForm.Get("code")]
+	delete(provider.authorizations, r.PostForm.Get("code"))
+	provider.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || clientID != mockOIDCClientID || clientSecret != mockOIDCClientSecret ||
+		authorization.query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := provider.keys.Sign(jwt.MapClaims{
+		"iss":                provider.URL,
+		"aud":                mockOIDCClientID,
+		"sub":                authorization.user.Subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              authorization.query.Get("nonce"),
+		"email":              authorization.user.Email,
+		"email_verified":     authorization.user.EmailVerified,
+		"preferred_username": authorization.user.PreferredUsername,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": "someAccessToken",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func (provider *MockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{"keys": provider.keys.JWKs()})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -1,8 +1,21 @@
 package testutils
 
 import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
 	"github.com/Krajiyah/nimble-interview-backend/internal/migrations"
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/Krajiyah/nimble-interview-backend/internal/routes/middlewares"
 	"github.com/Krajiyah/nimble-interview-backend/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
 )
 
 func NewUnitDeps() (utils.Deps, string, error) {
@@ -17,3 +30,44 @@ func NewUnitDeps() (utils.Deps, string, error) {
 
 	return unitDeps, fileName, nil
 }
+
+func NewUserWithToken(t *testing.T, deps utils.Deps, username string) (*models.User, string) {
+	user, err := models.NewUser(deps.DB(), &models.User{
+		Username:     username,
+		PasswordHash: "someHashOfPassword" + username,
+	})
+	require.NoError(t, err)
+	token, err := utils.NewJWT(deps.KeyRing(), user, "", time.Hour)
+	require.NoError(t, err)
+	return user, token
+}
+
+// DoRequest sends an authenticated JSON request to the test server and
+// decodes the standard response envelope.
+func DoRequest(t *testing.T, server *httptest.Server, method, path, token, body string) (int, utils.Response) {
+	return doRequest(t, server, method, path, middlewares.JwtRequestHeader, token, body)
+}
+
+// DoBearerRequest is DoRequest authenticated with an API key.
+func DoBearerRequest(t *testing.T, server *httptest.Server, method, path, apiKey, body string) (int, utils.Response) {
+	return doRequest(t, server, method, path, echo.HeaderAuthorization, "Bearer "+apiKey, body)
+}
+
+func doRequest(t *testing.T, server *httptest.Server, method, path, authHeader, auth, body string) (int, utils.Response) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r, err := http.NewRequest(method, server.URL+path, reader)
+	require.NoError(t, err)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	r.Header.Set(authHeader, auth)
+	w, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer w.Body.Close()
+	responseBody, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	res := utils.Response{}
+	require.NoError(t, json.Unmarshal(responseBody, &res), string(responseBody))
+	return w.StatusCode, res
+}
@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	attemptThresholdEnv   = "ATTEMPT_THRESHOLD"
+	ipAttemptThresholdEnv = "IP_ATTEMPT_THRESHOLD"
+	attemptLockoutEnv     = "ATTEMPT_LOCKOUT"
+	attemptMaxLockoutEnv  = "ATTEMPT_MAX_LOCKOUT"
+
+	defaultAttemptThreshold   = 5
+	defaultIPAttemptThreshold = 50 // many users may share an IP behind a NAT
+	defaultAttemptLockout     = time.Minute
+	defaultAttemptMaxLockout  = time.Hour
+
+	failedAttemptWindow = 15 * time.Minute
+	forgetAttemptsAfter = 24 * time.Hour
+	pruneAttemptsEvery  = time.Minute
+)
+
+// AttemptStore keeps the counters of an AttemptLimiter.
+type AttemptStore interface {
+	// Get returns an empty counter for keys without failures.
+	Get(key string) (*models.AttemptCounter, error)
+	// Fail must count a failure at now atomically, so concurrent failures all
+	// count. Failures restart at one once the window has passed, and lockouts
+	// are forgotten a day after the last failure.
+	Fail(key string, now time.Time) (*models.AttemptCounter, error)
+	// Lock locks the counter's key out until until and restarts its window,
+	// unless a concurrent failure locked it out first, and reports whether it did.
+	Lock(counter *models.AttemptCounter, now time.Time, until time.Time) (bool, error)
+	Delete(key string) error
+	Prune(before time.Time) error
+}
+
+// AttemptPolicy locks a key out for Lockout once it fails Threshold times
+// within the window, doubling the lockout for every further lockout up to
+// MaxLockout. Lockouts are forgotten a day after the last failure.
+type AttemptPolicy struct {
+	Threshold  int
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// AttemptLimiter counts failed attempts per key, e.g. a username, and locks
+// the key out once it has failed too often.
+type AttemptLimiter struct {
+	store  AttemptStore
+	policy AttemptPolicy
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	lastPrune time.Time
+}
+
+func NewAttemptLimiter(store AttemptStore, policy AttemptPolicy) *AttemptLimiter {
+	return &AttemptLimiter{store: store, policy: policy, now: time.Now}
+}
+
+// SetClock replaces time.Now, e.g. for tests to move time forward rather
+// than sleep through lockouts.
+func (limiter *AttemptLimiter) SetClock(now func() time.Time) {
+	limiter.now = now
+}
+
+// NewProdAttemptLimiters returns limiters for accounts and for IPs, both
+// configured from the environment.
+func NewProdAttemptLimiters(store AttemptStore) (*AttemptLimiter, *AttemptLimiter, error) {
+	policy := AttemptPolicy{Threshold: defaultAttemptThreshold, Lockout: defaultAttemptLockout, MaxLockout: defaultAttemptMaxLockout}
+	ipThreshold := defaultIPAttemptThreshold
+	for env, value := range map[string]interface{}{
+		attemptThresholdEnv:   &policy.Threshold,
+		ipAttemptThresholdEnv: &ipThreshold,
+		attemptLockoutEnv:     &policy.Lockout,
+		attemptMaxLockoutEnv:  &policy.MaxLockout,
+	} {
+		if err := parseEnv(env, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	ipPolicy := policy
+	ipPolicy.Threshold = ipThreshold
+	return NewAttemptLimiter(store, policy), NewAttemptLimiter(store, ipPolicy), nil
+}
+
+func NewUnitAttemptLimiters() (*AttemptLimiter, *AttemptLimiter) {
+	store := NewMemoryAttemptStore()
+	policy := AttemptPolicy{Threshold: defaultAttemptThreshold, Lockout: defaultAttemptLockout, MaxLockout: defaultAttemptMaxLockout}
+	ipPolicy := policy
+	ipPolicy.Threshold = defaultIPAttemptThreshold
+	return NewAttemptLimiter(store, policy), NewAttemptLimiter(store, ipPolicy)
+}
+
+// Allow returns how long the caller must wait before the key may be tried
+// again, zero when it may be tried now.
+func (limiter *AttemptLimiter) Allow(key string) (time.Duration, error) {
+	counter, err := limiter.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if wait := counter.LockedUntil.Sub(limiter.now()); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt and returns the lockout it caused, if any.
+func (limiter *AttemptLimiter) Fail(key string) (time.Duration, error) {
+	now := limiter.now()
+	counter, err := limiter.store.Fail(key, now)
+	if err != nil {
+		return 0, err
+	}
+	var lockout time.Duration
+	if counter.Failures >= limiter.policy.Threshold {
+		lockout = limiter.policy.lockout(counter.Lockouts)
+		locked, err := limiter.store.Lock(counter, now, now.Add(lockout))
+		if err != nil {
+			return 0, err
+		}
+		if !locked {
+			lockout = 0
+		}
+	}
+	return lockout, limiter.prune(now)
+}
+
+func (limiter *AttemptLimiter) Reset(key string) error {
+	return limiter.store.Delete(key)
+}
+
+// prune forgets stale counters now and then, rather than on every failure.
+func (limiter *AttemptLimiter) prune(now time.Time) error {
+	limiter.mutex.Lock()
+	if now.Sub(limiter.lastPrune) < pruneAttemptsEvery {
+		limiter.mutex.Unlock()
+		return nil
+	}
+	limiter.lastPrune = now
+	limiter.mutex.Unlock()
+	return limiter.store.Prune(now.Add(-forgetAttemptsAfter))
+}
+
+func (policy AttemptPolicy) lockout(previousLockouts int) time.Duration {
+	lockout := policy.Lockout
+	i := 0
+	for i < previousLockouts && lockout < policy.MaxLockout {
+		lockout *= 2
+		i++
+	}
+	if lockout > policy.MaxLockout {
+		return policy.MaxLockout
+	}
+	return lockout
+}
+
+// MemoryAttemptStore only suits a single instance, since every instance
+// would keep its own counters.
+type MemoryAttemptStore struct {
+	mutex    sync.Mutex
+	counters map[string]models.AttemptCounter
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{counters: map[string]models.AttemptCounter{}}
+}
+
+func (store *MemoryAttemptStore) Get(key string) (*models.AttemptCounter, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	counter, ok := store.counters[key]
+	if !ok {
+		counter = models.AttemptCounter{Key: key}
+	}
+	return &counter, nil
+}
+
+func (store *MemoryAttemptStore) Fail(key string, now time.Time) (*models.AttemptCounter, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	counter, ok := store.counters[key]
+	if !ok || now.Sub(counter.LastFailureAt) >= forgetAttemptsAfter {
+		counter = models.AttemptCounter{Key: key}
+	}
+	if now.Sub(counter.WindowStart) >= failedAttemptWindow {
+		counter.Failures = 0
+		counter.WindowStart = now
+	}
+	counter.Failures++
+	counter.LastFailureAt = now
+	store.counters[key] = counter
+	return &counter, nil
+}
+
+func (store *MemoryAttemptStore) Lock(counter *models.AttemptCounter, now time.Time, until time.Time) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, ok := store.counters[counter.Key]
+	if !ok || stored.Lockouts != counter.Lockouts {
+		return false, nil
+	}
+	stored.Failures = 0
+	stored.WindowStart = now
+	stored.Lockouts++
+	stored.LockedUntil = until
+	store.counters[counter.Key] = stored
+	return true, nil
+}
+
+func (store *MemoryAttemptStore) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.counters, key)
+	return nil
+}
+
+func (store *MemoryAttemptStore) Prune(before time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for key, counter := range store.counters {
+		if counter.LastFailureAt.Before(before) {
+			delete(store.counters, key)
+		}
+	}
+	return nil
+}
+
+// DBAttemptStore shares counters between every instance of the backend.
+type DBAttemptStore struct {
+	db *gorm.DB
+}
+
+func NewDBAttemptStore(db *gorm.DB) *DBAttemptStore {
+	return &DBAttemptStore{db}
+}
+
+func (store *DBAttemptStore) Get(key string) (*models.AttemptCounter, error) {
+	return models.GetAttemptCounter(store.db, key)
+}
+
+func (store *DBAttemptStore) Fail(key string, now time.Time) (*models.AttemptCounter, error) {
+	return models.FailAttemptCounter(store.db, key, now, failedAttemptWindow, forgetAttemptsAfter)
+}
+
+func (store *DBAttemptStore) Lock(counter *models.AttemptCounter, now time.Time, until time.Time) (bool, error) {
+	return models.LockAttemptCounter(store.db, counter, now, until)
+}
+
+func (store *DBAttemptStore) Delete(key string) error {
+	return models.DeleteAttemptCounter(store.db, key)
+}
+
+func (store *DBAttemptStore) Prune(before time.Time) error {
+	return models.PruneAttemptCounters(store.db, before)
+}
+
+// parseEnv parses env into value, an *int or *time.Duration, if it is set.
+func parseEnv(env string, value interface{}) error {
+	s := os.Getenv(env)
+	if s == "" {
+		return nil
+	}
+	var err error
+	switch v := value.(type) {
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *time.Duration:
+		*v, err = time.ParseDuration(s)
+	}
+	return errors.Wrap(err, "invalid "+env)
+}
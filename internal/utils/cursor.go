@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Cursor points at a row in a list ordered newest first by (created_at, id).
+// Clients only ever see it as an opaque string.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
+}
+
+type CursorQuery struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
+
+func (cursor Cursor) Encode() string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed cursor")
+	}
+	cursor := &Cursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, errors.Wrap(err, "malformed cursor")
+	}
+	return cursor, nil
+}
+
+// ParseCursorQuery reads the before/after cursors and page_size, and returns
+// nil when neither cursor is given, so clients that only send page or
+// page_size keep getting the legacy offset pages.
+func ParseCursorQuery(c echo.Context) (*CursorQuery, error) {
+	if c.QueryParam("before") == "" && c.QueryParam("after") == "" {
+		return nil, nil
+	}
+
+	query := &CursorQuery{Limit: parsePageSize(c)}
+	if s := c.QueryParam("before"); s != "" {
+		cursor, err := DecodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		query.Before = cursor
+	}
+	if s := c.QueryParam("after"); s != "" {
+		cursor, err := DecodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		query.After = cursor
+	}
+	if query.Before != nil && query.After != nil {
+		return nil, errors.New("only one of before and after may be given")
+	}
+	return query, nil
+}
+
+// Scope fetches one row more than the limit so callers can tell whether
+// another page exists. Rows come back oldest first when paging with After and
+// must be reversed by the caller.
+func (query *CursorQuery) Scope(table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		createdAt, id := table+".created_at", table+".id"
+		switch {
+		case query.Before != nil:
+			db = db.Where("("+createdAt+" < ? OR ("+createdAt+" = ? AND "+id+" < ?))", query.Before.CreatedAt, query.Before.CreatedAt, query.Before.ID).
+				Order(createdAt + " DESC").Order(id + " DESC")
+		case query.After != nil:
+			db = db.Where("("+createdAt+" > ? OR ("+createdAt+" = ? AND "+id+" > ?))", query.After.CreatedAt, query.After.CreatedAt, query.After.ID).
+				Order(createdAt + " ASC").Order(id + " ASC")
+		default:
+			db = db.Order(createdAt + " DESC").Order(id + " DESC")
+		}
+		return db.Limit(query.Limit + 1)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -14,10 +17,12 @@ import (
 const (
 	sslMode = "disable" // would enable this in production
 	tries   = 10
+
+	pgUniqueViolation = "23505"
 )
 
-func NewProdDB() (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
+func NewProdDSN() string {
+	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		os.Getenv("POSTGRES_HOST"),
 		os.Getenv("POSTGRES_USER"),
@@ -26,7 +31,9 @@ func NewProdDB() (*gorm.DB, error) {
 		os.Getenv("POSTGRES_PORT"),
 		sslMode,
 	)
+}
 
+func NewProdDB(dsn string) (*gorm.DB, error) {
 	i := 0
 	var err error
 	for i < tries {
@@ -51,3 +58,17 @@ func NewUnitDB() (*gorm.DB, string, error) {
 	db, err := gorm.Open(sqlite.Open(fileName), &gorm.Config{})
 	return db, fileName, err
 }
+
+// IsUniqueViolation reports whether err is a unique constraint violation, e.g.
+// from losing a race to insert the same row.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgUniqueViolation
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
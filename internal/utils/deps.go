@@ -1,51 +1,165 @@
 package utils
 
 import (
+	"os"
+
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+const (
+	appEnvEnv      = "APP_ENV"
+	developmentEnv = "development"
+)
+
 type Deps interface {
 	DB() *gorm.DB
 	Logger() *logrus.Logger
+	Hub() *Hub
+	PubSub() PubSub
+	KeyRing() *KeyRing
+	Attempts() *AttemptLimiter
+	IPAttempts() *AttemptLimiter
+	Mailer() Mailer
+	Secrets() *SecretBox
+	OIDC() *OIDCProviders
+	Credentials() *CredentialPolicy
+	Passwords() *PasswordHashers
 }
 
 type ProdDeps struct {
-	db     *gorm.DB
-	logger *logrus.Logger
+	db          *gorm.DB
+	logger      *logrus.Logger
+	hub         *Hub
+	pubsub      PubSub
+	keys        *KeyRing
+	attempts    *AttemptLimiter
+	ipAttempts  *AttemptLimiter
+	mailer      Mailer
+	secrets     *SecretBox
+	oidc        *OIDCProviders
+	credentials *CredentialPolicy
+	passwords   *PasswordHashers
 }
 
 type UnitDeps struct {
-	db     *gorm.DB
-	logger *logrus.Logger
+	db          *gorm.DB
+	logger      *logrus.Logger
+	hub         *Hub
+	pubsub      PubSub
+	keys        *KeyRing
+	attempts    *AttemptLimiter
+	ipAttempts  *AttemptLimiter
+	mailer      Mailer
+	secrets     *SecretBox
+	oidc        *OIDCProviders
+	credentials *CredentialPolicy
+	passwords   *PasswordHashers
 }
 
 func NewProdDeps() (Deps, error) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
 
-	db, err := NewProdDB()
+	keys, err := NewProdKeyRing(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := NewProdSecretBox(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := NewProdOIDCProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := NewProdCredentialPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	passwords, err := NewProdPasswordHashers()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := NewProdDSN()
+	db, err := NewProdDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, ipAttempts, err := NewProdAttemptLimiters(NewDBAttemptStore(db))
 	if err != nil {
 		return nil, err
 	}
 
-	return &ProdDeps{db: db, logger: logger}, nil
+	hub := NewHub()
+	pubsub := NewPostgresPubSub(db, dsn, logger)
+	relayMessagesToHub(db, pubsub, hub, logger)
+
+	return &ProdDeps{db: db, logger: logger, hub: hub, pubsub: pubsub, keys: keys, attempts: attempts, ipAttempts: ipAttempts, mailer: NewProdMailer(), secrets: secrets, oidc: oidc, credentials: credentials, passwords: passwords}, nil
 }
 
-func (deps *ProdDeps) DB() *gorm.DB           { return deps.db }
-func (deps *ProdDeps) Logger() *logrus.Logger { return deps.logger }
+func (deps *ProdDeps) DB() *gorm.DB                   { return deps.db }
+func (deps *ProdDeps) Logger() *logrus.Logger         { return deps.logger }
+func (deps *ProdDeps) Hub() *Hub                      { return deps.hub }
+func (deps *ProdDeps) PubSub() PubSub                 { return deps.pubsub }
+func (deps *ProdDeps) KeyRing() *KeyRing              { return deps.keys }
+func (deps *ProdDeps) Attempts() *AttemptLimiter      { return deps.attempts }
+func (deps *ProdDeps) IPAttempts() *AttemptLimiter    { return deps.ipAttempts }
+func (deps *ProdDeps) Mailer() Mailer                 { return deps.mailer }
+func (deps *ProdDeps) Secrets() *SecretBox            { return deps.secrets }
+func (deps *ProdDeps) OIDC() *OIDCProviders           { return deps.oidc }
+func (deps *ProdDeps) Credentials() *CredentialPolicy { return deps.credentials }
+func (deps *ProdDeps) Passwords() *PasswordHashers    { return deps.passwords }
 
 func NewUnitDeps() (Deps, string, error) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
 
+	keys, err := NewUnitKeyRing()
+	if err != nil {
+		return nil, "", err
+	}
+
+	secrets, err := NewUnitSecretBox()
+	if err != nil {
+		return nil, "", err
+	}
+
 	db, fileName, err := NewUnitDB()
 	if err != nil {
 		return nil, "", err
 	}
 
-	return &UnitDeps{db: db, logger: logger}, fileName, nil
+	attempts, ipAttempts := NewUnitAttemptLimiters()
+
+	hub := NewHub()
+	pubsub := NewMemoryPubSub()
+	relayMessagesToHub(db, pubsub, hub, logger)
+
+	return &UnitDeps{db: db, logger: logger, hub: hub, pubsub: pubsub, keys: keys, attempts: attempts, ipAttempts: ipAttempts, mailer: NewMemoryMailer(), secrets: secrets, oidc: NewOIDCProviders(nil), credentials: NewUnitCredentialPolicy(), passwords: NewUnitPasswordHashers()}, fileName, nil
 }
 
-func (deps *UnitDeps) DB() *gorm.DB           { return deps.db }
-func (deps *UnitDeps) Logger() *logrus.Logger { return deps.logger }
+func (deps *UnitDeps) DB() *gorm.DB                   { return deps.db }
+func (deps *UnitDeps) Logger() *logrus.Logger         { return deps.logger }
+func (deps *UnitDeps) Hub() *Hub                      { return deps.hub }
+func (deps *UnitDeps) PubSub() PubSub                 { return deps.pubsub }
+func (deps *UnitDeps) KeyRing() *KeyRing              { return deps.keys }
+func (deps *UnitDeps) Attempts() *AttemptLimiter      { return deps.attempts }
+func (deps *UnitDeps) IPAttempts() *AttemptLimiter    { return deps.ipAttempts }
+func (deps *UnitDeps) Mailer() Mailer                 { return deps.mailer }
+func (deps *UnitDeps) Secrets() *SecretBox            { return deps.secrets }
+func (deps *UnitDeps) OIDC() *OIDCProviders           { return deps.oidc }
+func (deps *UnitDeps) Credentials() *CredentialPolicy { return deps.credentials }
+func (deps *UnitDeps) Passwords() *PasswordHashers    { return deps.passwords }
+
+// isDevelopment reports whether APP_ENV=development, which lets the backend
+// start without the secrets production needs.
+func isDevelopment() bool {
+	return os.Getenv(appEnvEnv) == developmentEnv
+}
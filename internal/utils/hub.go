@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+)
+
+const (
+	hubClientBufferSize = 256
+)
+
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*HubClient]struct{}
+	// bumped whenever who may read a channel changes, see ChannelAccessVersion
+	accessVersions map[uint]uint64
+}
+
+type HubClient struct {
+	hub  *Hub
+	send chan *models.Message
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: map[*HubClient]struct{}{}, accessVersions: map[uint]uint64{}}
+}
+
+func (hub *Hub) Subscribe() *HubClient {
+	client := &HubClient{hub: hub, send: make(chan *models.Message, hubClientBufferSize)}
+	hub.mu.Lock()
+	hub.clients[client] = struct{}{}
+	hub.mu.Unlock()
+	return client
+}
+
+// Broadcast never blocks on a client: one whose buffer is full is dropped and
+// its channel closed so the connection serving it can shut down.
+func (hub *Hub) Broadcast(message *models.Message) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for client := range hub.clients {
+		select {
+		case client.send <- message:
+		default:
+			hub.remove(client)
+		}
+	}
+}
+
+// InvalidateChannelAccess tells clients that cached who may read the channel
+// to check again.
+func (hub *Hub) InvalidateChannelAccess(channelID uint) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.accessVersions[channelID]++
+}
+
+// ChannelAccessVersion changes whenever access to the channel may have
+// changed, so access checked at one version holds until it does.
+func (hub *Hub) ChannelAccessVersion(channelID uint) uint64 {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.accessVersions[channelID]
+}
+
+func (hub *Hub) ClientCount() int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return len(hub.clients)
+}
+
+func (hub *Hub) remove(client *HubClient) {
+	if _, ok := hub.clients[client]; ok {
+		delete(hub.clients, client)
+		close(client.send)
+	}
+}
+
+func (client *HubClient) Messages() <-chan *models.Message { return client.send }
+
+func (client *HubClient) Close() {
+	client.hub.mu.Lock()
+	client.hub.remove(client)
+	client.hub.mu.Unlock()
+}
@@ -6,53 +6,147 @@ import (
 	"time"
 
 	"github.com/Krajiyah/nimble-interview-backend/internal/models"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 )
 
-func ValidateJWT(db *gorm.DB, token string) (*models.User, error) {
-	tokenObj, err := jwt.Parse(token, parseJWT(db))
+const (
+	emailVerificationAudience = "email-verification"
+	twoFactorAudience         = "two-factor-challenge"
+)
+
+// Claims identify the user by sub and the token itself by jti. SessionID is
+// the refresh session family the token was issued for, if any.
+type Claims struct {
+	jwt.StandardClaims
+	SessionID string `json:"sid,omitempty"`
+}
+
+func ValidateJWT(db *gorm.DB, keys *KeyRing, token string) (*models.User, error) {
+	user, _, err := ValidateJWTClaims(db, keys, token)
+	return user, err
+}
+
+// ValidateJWTClaims also rejects tokens that were revoked by logging out.
+func ValidateJWTClaims(db *gorm.DB, keys *KeyRing, token string) (*models.User, *Claims, error) {
+	claims := &Claims{}
+	tokenObj, err := jwt.ParseWithClaims(token, claims, keys.Keyfunc)
 	if err != nil || !tokenObj.Valid {
-		return nil, errors.Wrap(err, "Invalid JWT")
+		return nil, nil, errors.Wrap(err, "Invalid JWT")
+	}
+	if !claims.VerifyIssuer(keys.Issuer, true) || !claims.VerifyAudience(keys.Audience, true) {
+		return nil, nil, errors.New("JWT issued for someone else")
+	}
+
+	revoked, err := models.IsTokenRevoked(db, claims.Id, claims.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, errors.New("Revoked JWT")
+	}
+
+	user, err := userFromClaims(db, claims)
+	if err != nil {
+		return nil, nil, err
 	}
-	s := tokenObj.Claims.(jwt.MapClaims)["jti"].(string)
-	id, err := strconv.Atoi(s)
+	return user, claims, nil
+}
+
+func userFromClaims(db *gorm.DB, claims *Claims) (*models.User, error) {
+	id, err := strconv.Atoi(claims.Subject)
 	if err != nil {
 		return nil, err
 	}
 	return models.GetUserByID(db, uint(id))
 }
 
-func parseJWT(db *gorm.DB) jwt.Keyfunc {
-	return func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+func NewJWT(keys *KeyRing, user *models.User, sessionID string, sessionDuration time.Duration) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			Issuer:    keys.Issuer,
+			Audience:  keys.Audience,
+			Subject:   fmt.Sprintf("%d", user.ID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(sessionDuration).Unix(),
+		},
+		SessionID: sessionID,
+	}
+	return keys.Sign(claims)
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return nil, errors.New("Malformed Claims in JWT")
-		}
+// EmailVerificationClaims are for a different audience than access tokens, so
+// neither kind of token can be used as the other.
+type EmailVerificationClaims struct {
+	jwt.StandardClaims
+	Email string `json:"email"`
+}
+
+func NewEmailVerificationJWT(keys *KeyRing, user *models.User, email string, duration time.Duration) (string, error) {
+	now := time.Now()
+	return keys.Sign(EmailVerificationClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    keys.Issuer,
+			Audience:  emailVerificationAudience,
+			Subject:   fmt.Sprintf("%d", user.ID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
+		},
+		Email: email,
+	})
+}
 
-		s := claims["jti"].(string)
-		id, err := strconv.Atoi(s)
-		if err != nil {
-			return nil, err
-		}
-		user, err := models.GetUserByID(db, uint(id))
-		if err != nil {
-			return nil, err
-		}
-		return []byte(user.PasswordHash), nil
+// ValidateEmailVerificationJWT returns the ID of the user the token was sent
+// to and the email it was sent to.
+func ValidateEmailVerificationJWT(keys *KeyRing, token string) (uint, string, error) {
+	claims := &EmailVerificationClaims{}
+	id, err := parseAudienceJWT(keys, token, claims, &claims.StandardClaims, emailVerificationAudience)
+	if err != nil {
+		return 0, "", err
 	}
+	return id, claims.Email, nil
 }
 
-func NewJWT(user *models.User, sessionDuration time.Duration) (string, error) {
-	claims := jwt.StandardClaims{
-		Id:        fmt.Sprintf("%d", user.ID),
-		ExpiresAt: time.Now().Add(sessionDuration).Unix(),
+// NewTwoFactorChallengeJWT proves the user passed the password step of a
+// login, it is not an access token.
+func NewTwoFactorChallengeJWT(keys *KeyRing, user *models.User, duration time.Duration) (string, error) {
+	now := time.Now()
+	return keys.Sign(jwt.StandardClaims{
+		Issuer:    keys.Issuer,
+		Audience:  twoFactorAudience,
+		Subject:   fmt.Sprintf("%d", user.ID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
+	})
+}
+
+// ValidateTwoFactorChallengeJWT returns the ID of the user logging in.
+func ValidateTwoFactorChallengeJWT(keys *KeyRing, token string) (uint, error) {
+	claims := &jwt.StandardClaims{}
+	return parseAudienceJWT(keys, token, claims, claims, twoFactorAudience)
+}
+
+// parseAudienceJWT parses a token into claims, whose standard part is
+// standard, and returns its subject if it was issued for audience.
+func parseAudienceJWT(keys *KeyRing, token string, claims jwt.Claims, standard *jwt.StandardClaims, audience string) (uint, error) {
+	tokenObj, err := jwt.ParseWithClaims(token, claims, keys.Keyfunc)
+	if err != nil || !tokenObj.Valid {
+		return 0, errors.Wrap(err, "Invalid JWT")
+	}
+	if !standard.VerifyIssuer(keys.Issuer, true) || !standard.VerifyAudience(audience, true) {
+		return 0, errors.New("JWT issued for something else")
+	}
+	id, err := strconv.Atoi(standard.Subject)
+	if err != nil {
+		return 0, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(user.PasswordHash))
+	return uint(id), nil
 }
@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	keysEnv         = "JWT_KEYS"
+	signingKeyIDEnv = "JWT_SIGNING_KEY_ID"
+	issuerEnv       = "JWT_ISSUER"
+	audienceEnv     = "JWT_AUDIENCE"
+
+	DefaultIssuer   = "nimble-interview-backend"
+	DefaultAudience = "nimble-interview"
+
+	unitKeyID = "unit"
+	devKeyID  = "dev"
+)
+
+// compromisedKeyIDs and compromisedKeys name keys whose private half was
+// exposed, by kid and by the SHA-256 of the public key (or HS256 secret). No
+// ring loads them, so nothing they signed is ever accepted again.
+var (
+	compromisedKeyIDs = map[string]bool{"dev-2021-10": true}
+	compromisedKeys   = map[string]bool{"17af59e82d9cc64ceedff14cc4f1d289bae6e774cfb9ebd3254e57ec89299eee": true}
+)
+
+// KeyConfig is one entry of the JWT_KEYS JSON array. Key is a base64 secret
+// for HS256 and a PEM private key for RS256 and EdDSA.
+type KeyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Key       string `json:"key"`
+}
+
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+// JWK is the public half of an asymmetric key as published in a JWKS.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// KeyRing signs tokens with one key and verifies them with any of its keys,
+// picked by the kid header. Keys are rotated by:
+//  1. adding the new key to JWT_KEYS, so every instance can verify with it
+//  2. once cached JWKS have expired, pointing JWT_SIGNING_KEY_ID at it
+//  3. removing the old key once the tokens it signed have all expired
+type KeyRing struct {
+	Issuer   string
+	Audience string
+	signing  *SigningKey
+	keys     []*SigningKey
+	byID     map[string]*SigningKey
+}
+
+// NewKeyRing signs with the key named by signingKeyID, or the first key when
+// it is empty.
+func NewKeyRing(configs []KeyConfig, signingKeyID string) (*KeyRing, error) {
+	ring := &KeyRing{Issuer: DefaultIssuer, Audience: DefaultAudience, byID: map[string]*SigningKey{}}
+	for _, config := range configs {
+		key, err := newSigningKey(config)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid key %q", config.ID))
+		}
+		if _, ok := ring.byID[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key.ID)
+		}
+		ring.keys = append(ring.keys, key)
+		ring.byID[key.ID] = key
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	ring.signing = ring.keys[0]
+	if signingKeyID != "" {
+		key, ok := ring.byID[signingKeyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", signingKeyID)
+		}
+		ring.signing = key
+	}
+	return ring, nil
+}
+
+// NewProdKeyRing reads the keys from JWT_KEYS. In development it signs with a
+// throwaway key when JWT_KEYS is unset, so tokens stop working on restart.
+func NewProdKeyRing(logger *logrus.Logger) (*KeyRing, error) {
+	var ring *KeyRing
+	var err error
+	if os.Getenv(keysEnv) == "" {
+		if !isDevelopment() {
+			return nil, errors.New(keysEnv + " is not set")
+		}
+		logger.Warn(keysEnv + " is not set, signing tokens with a throwaway key")
+		ring, err = newDevKeyRing()
+	} else {
+		configs := []KeyConfig{}
+		if err := json.Unmarshal([]byte(os.Getenv(keysEnv)), &configs); err != nil {
+			return nil, errors.Wrap(err, "could not parse "+keysEnv)
+		}
+		ring, err = NewKeyRing(configs, os.Getenv(signingKeyIDEnv))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if issuer := os.Getenv(issuerEnv); issuer != "" {
+		ring.Issuer = issuer
+	}
+	if audience := os.Getenv(audienceEnv); audience != "" {
+		ring.Audience = audience
+	}
+	return ring, nil
+}
+
+// newDevKeyRing holds a single freshly generated EdDSA key, published in the
+// JWKS like a configured one would be.
+func newDevKeyRing() (*KeyRing, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing([]KeyConfig{{
+		ID:        devKeyID,
+		Algorithm: jwt.SigningMethodEdDSA.Alg(),
+		Key:       string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}}, "")
+}
+
+// NewUnitKeyRing holds a single freshly generated HS256 key.
+func NewUnitKeyRing() (*KeyRing, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewKeyRing([]KeyConfig{{
+		ID:        unitKeyID,
+		Algorithm: jwt.SigningMethodHS256.Alg(),
+		Key:       base64.StdEncoding.EncodeToString(secret),
+	}}, "")
+}
+
+func newSigningKey(config KeyConfig) (*SigningKey, error) {
+	if config.ID == "" {
+		return nil, errors.New("missing kid")
+	}
+	if compromisedKeyIDs[config.ID] {
+		return nil, errors.New("key is compromised")
+	}
+	key := &SigningKey{ID: config.ID, Method: jwt.GetSigningMethod(config.Algorithm)}
+
+	var err error
+	switch key.Method {
+	case jwt.SigningMethodHS256:
+		var secret []byte
+		secret, err = base64.StdEncoding.DecodeString(config.Key)
+		key.privateKey, key.publicKey = secret, secret
+	case jwt.SigningMethodRS256:
+		var privateKey *rsa.PrivateKey
+		privateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(config.Key))
+		if err == nil {
+			key.privateKey, key.publicKey = privateKey, &privateKey.PublicKey
+		}
+	case jwt.SigningMethodEdDSA:
+		var privateKey crypto.PrivateKey
+		privateKey, err = jwt.ParseEdPrivateKeyFromPEM([]byte(config.Key))
+		if err == nil {
+			edKey := privateKey.(ed25519.PrivateKey)
+			key.privateKey, key.publicKey = edKey, edKey.Public().(ed25519.PublicKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := key.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if compromisedKeys[fingerprint] {
+		return nil, errors.New("key is compromised")
+	}
+	return key, nil
+}
+
+// fingerprint is the hex SHA-256 of the public key, or of the secret for
+// HS256.
+func (key *SigningKey) fingerprint() (string, error) {
+	var raw []byte
+	switch publicKey := key.publicKey.(type) {
+	case []byte:
+		raw = publicKey
+	case ed25519.PublicKey:
+		raw = publicKey
+	default:
+		var err error
+		if raw, err = x509.MarshalPKIXPublicKey(publicKey); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (ring *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ring.signing.Method, claims)
+	token.Header["kid"] = ring.signing.ID
+	return token.SignedString(ring.signing.privateKey)
+}
+
+// Keyfunc only accepts tokens signed with the algorithm of the key they name,
+// so an RS256 public key can never be used as an HS256 secret.
+func (ring *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key: %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.publicKey, nil
+}
+
+// JWKs lists the public keys of the asymmetric keys in the ring, shared
+// secrets are never published.
+func (ring *KeyRing) JWKs() []JWK {
+	result := []JWK{}
+	for _, key := range ring.keys {
+		jwk := JWK{ID: key.ID, Algorithm: key.Method.Alg(), Use: "sig"}
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+		result = append(result, jwk)
+	}
+	return result
+}
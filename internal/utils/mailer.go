@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(mail *Mail) error
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewProdMailer authenticates only when SMTP_USERNAME is set, which suits
+// local relays like the mailhog service in docker-compose.
+func NewProdMailer() *SMTPMailer {
+	host := os.Getenv("SMTP_HOST")
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(host, os.Getenv("SMTP_PORT")),
+		from: os.Getenv("MAIL_FROM"),
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		mailer.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return mailer
+}
+
+func (mailer *SMTPMailer) Send(mail *Mail) error {
+	if err := checkMailHeaders(mail); err != nil {
+		return err
+	}
+	msg := strings.Join([]string{
+		"From: " + mailer.from,
+		"To: " + mail.To,
+		"Subject: " + mail.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		mail.Body,
+	}, "\r\n")
+	return smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{mail.To}, []byte(msg))
+}
+
+// MemoryMailer captures mail instead of sending it, for unit tests.
+type MemoryMailer struct {
+	mutex sync.Mutex
+	mails []Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mailer *MemoryMailer) Send(mail *Mail) error {
+	if err := checkMailHeaders(mail); err != nil {
+		return err
+	}
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.mails = append(mailer.mails, *mail)
+	return nil
+}
+
+func (mailer *MemoryMailer) Sent() []Mail {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	return append([]Mail{}, mailer.mails...)
+}
+
+// checkMailHeaders stops user input from injecting headers.
+func checkMailHeaders(mail *Mail) error {
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail headers for %q", mail.To)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcProvidersEnv = "OIDC_PROVIDERS"
+)
+
+// OIDCProviderConfig is one entry of the OIDC_PROVIDERS JSON array.
+// RedirectURL is where the provider sends the user back to, usually a deep
+// link into the app, which then hands the code to the API.
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"` // openid, email and profile when empty
+}
+
+// OIDCProvider is a configured provider after discovery.
+type OIDCProvider struct {
+	Name     string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// OIDCIdentity is what a verified ID token says about the user.
+type OIDCIdentity struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+// OIDCProviders discovers each provider on first use rather than on startup,
+// so one unreachable provider does not keep the backend from starting.
+type OIDCProviders struct {
+	mutex     sync.Mutex
+	configs   map[string]OIDCProviderConfig
+	providers map[string]*OIDCProvider
+}
+
+func NewOIDCProviders(configs []OIDCProviderConfig) *OIDCProviders {
+	providers := &OIDCProviders{
+		configs:   map[string]OIDCProviderConfig{},
+		providers: map[string]*OIDCProvider{},
+	}
+	for _, config := range configs {
+		providers.configs[config.Name] = config
+	}
+	return providers
+}
+
+// NewProdOIDCProviders reads OIDC_PROVIDERS, no providers are configured
+// when it is unset.
+func NewProdOIDCProviders() (*OIDCProviders, error) {
+	configs := []OIDCProviderConfig{}
+	if env := os.Getenv(oidcProvidersEnv); env != "" {
+		if err := json.Unmarshal([]byte(env), &configs); err != nil {
+			return nil, errors.Wrap(err, "invalid "+oidcProvidersEnv)
+		}
+	}
+	return NewOIDCProviders(configs), nil
+}
+
+// Get discovers the provider without holding the lock, so a slow or
+// unreachable provider only delays its own logins. Concurrent first logins may
+// both discover it, only successful discoveries are kept.
+func (providers *OIDCProviders) Get(ctx context.Context, name string) (*OIDCProvider, error) {
+	providers.mutex.Lock()
+	provider, discovered := providers.providers[name]
+	config, configured := providers.configs[name]
+	providers.mutex.Unlock()
+
+	if discovered {
+		return provider, nil
+	}
+	if !configured {
+		return nil, errors.Errorf("unknown oidc provider %q", name)
+	}
+
+	discovery, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not discover oidc provider")
+	}
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	provider = &OIDCProvider{
+		Name: name,
+		config: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     discovery.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: discovery.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}
+
+	providers.mutex.Lock()
+	defer providers.mutex.Unlock()
+	providers.providers[name] = provider
+	return provider, nil
+}
+
+// AuthCodeURL is where the user signs in, using PKCE with the S256 challenge
+// of verifier.
+func (provider *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return provider.config.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+}
+
+// Exchange trades the code for tokens and verifies the ID token, including
+// that it was issued for nonce.
+func (provider *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCIdentity, error) {
+	token, err := provider.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not exchange code")
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("missing id token")
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid id token")
+	}
+	identity := &OIDCIdentity{}
+	if err := idToken.Claims(identity); err != nil {
+		return nil, err
+	}
+	if identity.Nonce != nonce {
+		return nil, errors.New("id token issued for another login")
+	}
+	return identity, nil
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordHasherEnv = "PASSWORD_HASHER"
+	argon2MemoryEnv   = "ARGON2_MEMORY"
+	argon2TimeEnv     = "ARGON2_TIME"
+	argon2ThreadsEnv  = "ARGON2_THREADS"
+	bcryptCostEnv     = "BCRYPT_COST"
+
+	argon2idName = "argon2id"
+	bcryptName   = "bcrypt"
+
+	// OWASP's recommendation for argon2id
+	defaultArgon2Memory  = 19 * 1024 // KiB
+	defaultArgon2Time    = 2
+	defaultArgon2Threads = 1
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordHasher hashes passwords into self-describing strings, which name
+// the algorithm and the cost they were hashed with.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Owns reports whether hash was made by this algorithm, at any cost.
+	Owns(hash string) bool
+	Verify(password, hash string) bool
+	// Weaker reports whether hash was made at a lower cost than Hash uses.
+	Weaker(hash string) bool
+}
+
+// PasswordHashers hashes new passwords with the preferred hasher, but still
+// verifies hashes of any of the others, e.g. ones users signed up with before
+// the preferred hasher changed.
+type PasswordHashers struct {
+	preferred PasswordHasher
+	others    []PasswordHasher
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordHashers(preferred PasswordHasher, others ...PasswordHasher) *PasswordHashers {
+	return &PasswordHashers{preferred: preferred, others: others}
+}
+
+// NewProdPasswordHashers prefers PASSWORD_HASHER, argon2id by default, and
+// configures both hashers from the environment.
+func NewProdPasswordHashers() (*PasswordHashers, error) {
+	argon2id := &Argon2idHasher{Memory: defaultArgon2Memory, Time: defaultArgon2Time, Threads: defaultArgon2Threads}
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.DefaultCost}
+	for env, value := range map[string]interface{}{
+		argon2MemoryEnv:  &argon2id.Memory,
+		argon2TimeEnv:    &argon2id.Time,
+		argon2ThreadsEnv: &argon2id.Threads,
+		bcryptCostEnv:    &bcryptHasher.Cost,
+	} {
+		if err := parseEnv(env, value); err != nil {
+			return nil, err
+		}
+	}
+	if argon2id.Memory < 1 || argon2id.Time < 1 || argon2id.Threads < 1 || argon2id.Threads > 255 {
+		return nil, errors.New("invalid argon2 parameters")
+	}
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		return nil, errors.New("invalid " + bcryptCostEnv)
+	}
+
+	switch os.Getenv(passwordHasherEnv) {
+	case "", argon2idName:
+		return NewPasswordHashers(argon2id, bcryptHasher), nil
+	case bcryptName:
+		return NewPasswordHashers(bcryptHasher, argon2id), nil
+	}
+	return nil, errors.New("invalid " + passwordHasherEnv)
+}
+
+// NewUnitPasswordHashers hashes cheaply to keep tests fast.
+func NewUnitPasswordHashers() *PasswordHashers {
+	return NewPasswordHashers(&Argon2idHasher{Memory: 1024, Time: 1, Threads: 1}, &BcryptHasher{Cost: bcrypt.MinCost})
+}
+
+func (hashers *PasswordHashers) Hash(password string) (string, error) {
+	return hashers.preferred.Hash(password)
+}
+
+// Verify reports whether password matches hash, and if so whether hash should
+// be replaced by a new Hash of password. Hashes none of the hashers own never
+// match, e.g. the empty hash of users without a password or of usernames that
+// do not exist, but take as long as a preferred hash so timing does not tell.
+func (hashers *PasswordHashers) Verify(password, hash string) (ok bool, rehash bool) {
+	if hashers.preferred.Owns(hash) {
+		ok = hashers.preferred.Verify(password, hash)
+		return ok, ok && hashers.preferred.Weaker(hash)
+	}
+	for _, hasher := range hashers.others {
+		if hasher.Owns(hash) {
+			return hasher.Verify(password, hash), true
+		}
+	}
+	hashers.dummyOnce.Do(func() {
+		hashers.dummyHash, _ = hashers.preferred.Hash("dummy password")
+	})
+	hashers.preferred.Verify(password, hashers.dummyHash)
+	return false, false
+}
+
+// Argon2idHasher makes PHC strings like "$argon2id$v=19$m=19456,t=2,p=1$salt$key",
+// with Memory in KiB.
+type Argon2idHasher struct {
+	Memory  int
+	Time    int
+	Threads int
+}
+
+type argon2idHash struct {
+	memory, time, threads int
+	salt, key             []byte
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(hasher.Time), uint32(hasher.Memory), uint8(hasher.Threads), argon2KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2idName, argon2.Version, hasher.Memory, hasher.Time, hasher.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (hasher *Argon2idHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$"+argon2idName+"$")
+}
+
+func (hasher *Argon2idHasher) Verify(password, hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), parsed.salt, uint32(parsed.time), uint32(parsed.memory), uint8(parsed.threads), uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1
+}
+
+func (hasher *Argon2idHasher) Weaker(hash string) bool {
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return parsed.memory < hasher.Memory || parsed.time < hasher.Time || len(parsed.key) < argon2KeyLength
+}
+
+func parseArgon2idHash(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != argon2idName {
+		return nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2 version")
+	}
+	parsed := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.time, &parsed.threads); err != nil {
+		return nil, errors.Wrap(err, "invalid argon2 parameters")
+	}
+	if parsed.memory < 1 || parsed.time < 1 || parsed.threads < 1 || parsed.threads > 255 {
+		return nil, errors.New("invalid argon2 parameters")
+	}
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.Wrap(err, "invalid argon2 salt")
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(parsed.key) == 0 {
+		return nil, errors.New("invalid argon2 key")
+	}
+	return parsed, nil
+}
+
+// BcryptHasher only uses the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (hasher *BcryptHasher) Owns(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (hasher *BcryptHasher) Verify(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (hasher *BcryptHasher) Weaker(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < hasher.Cost
+}
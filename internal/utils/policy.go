@@ -0,0 +1,173 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	passwordMinLengthEnv  = "PASSWORD_MIN_LENGTH"
+	passwordMaxLengthEnv  = "PASSWORD_MAX_LENGTH"
+	passwordMinClassesEnv = "PASSWORD_MIN_CLASSES"
+	usernameMinLengthEnv  = "USERNAME_MIN_LENGTH"
+	usernameMaxLengthEnv  = "USERNAME_MAX_LENGTH"
+	reservedUsernamesEnv  = "RESERVED_USERNAMES"
+
+	defaultPasswordMinLength  = 8
+	defaultPasswordMaxLength  = 64
+	defaultPasswordMinClasses = 2
+	defaultUsernameMinLength  = 3
+	defaultUsernameMaxLength  = 64 // usernames used to be email addresses
+)
+
+// The rules a FieldError can name.
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleCharacterClasses = "character_classes"
+	RuleCommonPassword   = "common_password"
+	RuleContainsUsername = "contains_username"
+	RuleCharset          = "charset"
+	RuleReserved         = "reserved"
+	RuleTaken            = "taken"
+)
+
+var (
+	//go:embed common_passwords.txt
+	commonPasswords string
+
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]*$`)
+
+	defaultReservedUsernames = []string{
+		"admin", "administrator", "api", "everyone", "help", "here", "me", "mod",
+		"moderator", "nimble", "null", "root", "security", "staff", "support",
+		"system", "undefined",
+	}
+)
+
+// CredentialPolicy decides which usernames and passwords users may choose.
+// Lengths are counted in characters, names are compared case-insensitively.
+type CredentialPolicy struct {
+	PasswordMinLength  int
+	PasswordMaxLength  int
+	PasswordMinClasses int // of lowercase, uppercase, digits and symbols
+	UsernameMinLength  int
+	UsernameMaxLength  int
+
+	commonPasswords   map[string]bool
+	reservedUsernames map[string]bool
+}
+
+// NewCredentialPolicy blocks the bundled common passwords and reserves the
+// given usernames on top of the default ones.
+func NewCredentialPolicy(reservedUsernames []string) *CredentialPolicy {
+	policy := &CredentialPolicy{
+		PasswordMinLength:  defaultPasswordMinLength,
+		PasswordMaxLength:  defaultPasswordMaxLength,
+		PasswordMinClasses: defaultPasswordMinClasses,
+		UsernameMinLength:  defaultUsernameMinLength,
+		UsernameMaxLength:  defaultUsernameMaxLength,
+		commonPasswords:    map[string]bool{},
+		reservedUsernames:  map[string]bool{},
+	}
+	for _, password := range strings.Split(commonPasswords, "\n") {
+		if password != "" {
+			policy.commonPasswords[strings.ToLower(password)] = true
+		}
+	}
+	for _, username := range append(defaultReservedUsernames, reservedUsernames...) {
+		if username = strings.TrimSpace(username); username != "" {
+			policy.reservedUsernames[strings.ToLower(username)] = true
+		}
+	}
+	return policy
+}
+
+func NewProdCredentialPolicy() (*CredentialPolicy, error) {
+	policy := NewCredentialPolicy(strings.Split(os.Getenv(reservedUsernamesEnv), ","))
+	for env, value := range map[string]interface{}{
+		passwordMinLengthEnv:  &policy.PasswordMinLength,
+		passwordMaxLengthEnv:  &policy.PasswordMaxLength,
+		passwordMinClassesEnv: &policy.PasswordMinClasses,
+		usernameMinLengthEnv:  &policy.UsernameMinLength,
+		usernameMaxLengthEnv:  &policy.UsernameMaxLength,
+	} {
+		if err := parseEnv(env, value); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func NewUnitCredentialPolicy() *CredentialPolicy {
+	return NewCredentialPolicy(nil)
+}
+
+// CheckUsername returns every rule username breaks. Whether it is taken is
+// left to the caller.
+func (policy *CredentialPolicy) CheckUsername(username string) []FieldError {
+	const field = "username"
+	errs := checkLength(field, username, policy.UsernameMinLength, policy.UsernameMaxLength)
+	if !usernamePattern.MatchString(username) {
+		errs = append(errs, FieldError{field, RuleCharset, "may only contain letters, digits, '.', '_', '-' and '@', and must start with a letter or digit"})
+	}
+	if policy.reservedUsernames[strings.ToLower(username)] {
+		errs = append(errs, FieldError{field, RuleReserved, "is reserved"})
+	}
+	return errs
+}
+
+// CheckPassword returns every rule the password in field breaks, for the user
+// with the given username.
+func (policy *CredentialPolicy) CheckPassword(field, password, username string) []FieldError {
+	errs := checkLength(field, password, policy.PasswordMinLength, policy.PasswordMaxLength)
+	if classes := characterClasses(password); classes < policy.PasswordMinClasses {
+		errs = append(errs, FieldError{field, RuleCharacterClasses, fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and symbols", policy.PasswordMinClasses)})
+	}
+	if policy.commonPasswords[strings.ToLower(password)] {
+		errs = append(errs, FieldError{field, RuleCommonPassword, "is too common"})
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		errs = append(errs, FieldError{field, RuleContainsUsername, "must not contain the username"})
+	}
+	return errs
+}
+
+func checkLength(field, value string, min, max int) []FieldError {
+	length := utf8.RuneCountInString(value)
+	if length < min {
+		return []FieldError{{field, RuleMinLength, fmt.Sprintf("must be at least %d characters", min)}}
+	}
+	if length > max {
+		return []FieldError{{field, RuleMaxLength, fmt.Sprintf("must be at most %d characters", max)}}
+	}
+	return nil
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	return classes
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Krajiyah/nimble-interview-backend/internal/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	MessageCreatedChannel       = "message_created"
+	ChannelAccessChangedChannel = "channel_access_changed"
+	listenRetryDelay            = 2 * time.Second
+)
+
+var (
+	errResubscribe = errors.New("subscriptions changed")
+)
+
+type PubSub interface {
+	Publish(channel string, payload string) error
+	Subscribe(channel string, handler func(payload string))
+}
+
+type handlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(string)
+}
+
+func (registry *handlerRegistry) add(channel string, handler func(string)) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.handlers[channel] = append(registry.handlers[channel], handler)
+}
+
+func (registry *handlerRegistry) channels() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	result := []string{}
+	for channel := range registry.handlers {
+		result = append(result, channel)
+	}
+	return result
+}
+
+func (registry *handlerRegistry) dispatch(channel string, payload string) {
+	registry.mu.RLock()
+	handlers := registry.handlers[channel]
+	registry.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(payload)
+	}
+}
+
+type MemoryPubSub struct {
+	registry handlerRegistry
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{registry: handlerRegistry{handlers: map[string][]func(string){}}}
+}
+
+func (ps *MemoryPubSub) Publish(channel string, payload string) error {
+	ps.registry.dispatch(channel, payload)
+	return nil
+}
+
+func (ps *MemoryPubSub) Subscribe(channel string, handler func(string)) {
+	ps.registry.add(channel, handler)
+}
+
+// PostgresPubSub publishes with NOTIFY through the shared pool and listens on
+// a dedicated connection, reconnecting whenever it drops.
+type PostgresPubSub struct {
+	db       *gorm.DB
+	dsn      string
+	logger   *logrus.Logger
+	registry handlerRegistry
+	changed  chan struct{}
+}
+
+func NewPostgresPubSub(db *gorm.DB, dsn string, logger *logrus.Logger) *PostgresPubSub {
+	ps := &PostgresPubSub{
+		db:       db,
+		dsn:      dsn,
+		logger:   logger,
+		registry: handlerRegistry{handlers: map[string][]func(string){}},
+		changed:  make(chan struct{}, 1),
+	}
+	go ps.listen()
+	return ps
+}
+
+func (ps *PostgresPubSub) Publish(channel string, payload string) error {
+	return ps.db.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
+
+func (ps *PostgresPubSub) Subscribe(channel string, handler func(string)) {
+	ps.registry.add(channel, handler)
+	select {
+	case ps.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (ps *PostgresPubSub) listen() {
+	logger := ps.logger.WithField("component", "PostgresPubSub")
+	for {
+		err := ps.listenOnce()
+		if err == errResubscribe {
+			continue
+		}
+		logger.WithError(err).Warn("lost listener connection...retrying")
+		time.Sleep(listenRetryDelay)
+	}
+}
+
+func (ps *PostgresPubSub) listenOnce() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, ps.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	for _, channel := range ps.registry.channels() {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		select {
+		case <-ps.changed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errResubscribe
+			}
+			return err
+		}
+		ps.registry.dispatch(notification.Channel, notification.Payload)
+	}
+}
+
+// PublishMessageCreated only sends the ID, as NOTIFY payloads are capped at
+// 8000 bytes; subscribers load the committed row themselves.
+func PublishMessageCreated(ps PubSub, message *models.Message) error {
+	return ps.Publish(MessageCreatedChannel, strconv.FormatUint(uint64(message.ID), 10))
+}
+
+// PublishChannelAccessChanged tells every instance that members were added or
+// removed, or the channel became public or private.
+func PublishChannelAccessChanged(ps PubSub, channelID uint) error {
+	return ps.Publish(ChannelAccessChangedChannel, strconv.FormatUint(uint64(channelID), 10))
+}
+
+func relayMessagesToHub(db *gorm.DB, ps PubSub, hub *Hub, logger *logrus.Logger) {
+	ps.Subscribe(MessageCreatedChannel, func(payload string) {
+		id, err := strconv.ParseUint(payload, 10, 64)
+		if err != nil {
+			logger.WithError(err).WithField("payload", payload).Warn("malformed message created event")
+			return
+		}
+		message := &models.Message{}
+		if err := db.First(message, id).Error; err != nil {
+			logger.WithError(err).WithField("id", id).Warn("could not load created message")
+			return
+		}
+		hub.Broadcast(message)
+	})
+	ps.Subscribe(ChannelAccessChangedChannel, func(payload string) {
+		id, err := strconv.ParseUint(payload, 10, 64)
+		if err != nil {
+			logger.WithError(err).WithField("payload", payload).Warn("malformed channel access changed event")
+			return
+		}
+		hub.InvalidateChannelAccess(uint(id))
+	})
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	secretsKeyEnv   = "SECRETS_KEY"
+	secretsKeyBytes = 32
+)
+
+// compromisedSecretsKeys are the hex SHA-256 of keys that were exposed, which
+// must never seal or open secrets again.
+var compromisedSecretsKeys = map[string]bool{
+	"1f15f0ef75403e4fd341b9bb7a99015bdd529fb8a1c26922ff589f9e86509039": true,
+}
+
+// SecretBox encrypts secrets the backend has to read back later, e.g. TOTP
+// secrets, with AES-256-GCM. Unlike secret tokens they cannot just be hashed.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != secretsKeyBytes {
+		return nil, errors.Errorf("secrets key must be %d bytes", secretsKeyBytes)
+	}
+	sum := sha256.Sum256(key)
+	if compromisedSecretsKeys[hex.EncodeToString(sum[:])] {
+		return nil, errors.New("secrets key is compromised")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead}, nil
+}
+
+// NewProdSecretBox reads the key from SECRETS_KEY as base64. In development it
+// uses a throwaway key when SECRETS_KEY is unset, so secrets sealed before a
+// restart cannot be opened after it.
+func NewProdSecretBox(logger *logrus.Logger) (*SecretBox, error) {
+	if os.Getenv(secretsKeyEnv) == "" {
+		if !isDevelopment() {
+			return nil, errors.New(secretsKeyEnv + " is not set")
+		}
+		logger.Warn(secretsKeyEnv + " is not set, sealing secrets with a throwaway key")
+		return NewUnitSecretBox()
+	}
+	key, err := base64.StdEncoding.DecodeString(os.Getenv(secretsKeyEnv))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid "+secretsKeyEnv)
+	}
+	return NewSecretBox(key)
+}
+
+func NewUnitSecretBox() (*SecretBox, error) {
+	key := make([]byte, secretsKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewSecretBox(key)
+}
+
+// Seal binds the ciphertext to context, e.g. the owner of the secret, so it
+// cannot be opened for any other context.
+func (box *SecretBox) Seal(plaintext string, context string) (string, error) {
+	nonce := make([]byte, box.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := box.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (box *SecretBox) Open(sealed string, context string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < box.aead.NonceSize() {
+		return "", errors.New("sealed secret too short")
+	}
+	nonce, ciphertext := b[:box.aead.NonceSize()], b[box.aead.NonceSize():]
+	plaintext, err := box.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
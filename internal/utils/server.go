@@ -1,17 +1,31 @@
 package utils
 
 import (
+	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+	linkHeader      = "Link"
+	trustProxyEnv   = "TRUST_PROXY"
+)
+
 const (
 	BadRequestMsg       = "invalid parameters"
 	InvalidAuthInfo     = "invalid auth info"
 	InternalServerError = "internal server error"
+	NotFoundMsg         = "not found"
+	ForbiddenMsg        = "forbidden"
+	TooManyAttemptsMsg  = "too many attempts"
+	UnverifiedEmailMsg  = "email not verified"
 )
 
 type Route interface {
@@ -22,8 +36,29 @@ type Route interface {
 }
 
 type Response struct {
-	Result interface{} `json:"result"`
-	Error  string      `json:"error"`
+	Result  interface{}  `json:"result"`
+	Error   string       `json:"error"`
+	Details []FieldError `json:"details,omitempty"` // why the request's fields were rejected
+	*Pagination
+}
+
+// FieldError explains which rule a field of the request broke.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Pagination describes either an offset page (Page is set) or a cursor page
+// of a list response. Offset pages may carry cursors too, so clients can
+// switch to cursor paging from the first page.
+type Pagination struct {
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	Total      int64  `json:"total"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"` // older results
+	PrevCursor string `json:"prev_cursor,omitempty"` // newer results
 }
 
 func NewSuccessResponse(result interface{}) Response {
@@ -34,8 +69,67 @@ func NewErrorResponse(msg string) Response {
 	return Response{Error: msg}
 }
 
+func NewFieldErrorResponse(details []FieldError) Response {
+	return Response{Error: BadRequestMsg, Details: details}
+}
+
+// NewPaginatedResponse also sets an RFC 5988 Link header pointing at the
+// neighbouring pages.
+func NewPaginatedResponse(c echo.Context, result interface{}, pagination *Pagination) Response {
+	if links := pagination.links(c.Request().URL); len(links) > 0 {
+		c.Response().Header().Set(linkHeader, strings.Join(links, ", "))
+	}
+	return Response{Result: result, Pagination: pagination}
+}
+
+func (pagination *Pagination) links(u *url.URL) []string {
+	links := []string{}
+	add := func(rel string, params map[string]string) {
+		link := *u
+		query := link.Query()
+		for _, key := range []string{"page", "before", "after"} {
+			query.Del(key)
+		}
+		for key, value := range params {
+			query.Set(key, value)
+		}
+		link.RawQuery = query.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, link.RequestURI(), rel))
+	}
+
+	if pagination.Page > 0 {
+		last := int((pagination.Total + int64(pagination.PageSize) - 1) / int64(pagination.PageSize))
+		if last < 1 {
+			last = 1
+		}
+		if pagination.HasMore {
+			add("next", map[string]string{"page": strconv.Itoa(pagination.Page + 1)})
+		}
+		if pagination.Page > 1 {
+			add("prev", map[string]string{"page": strconv.Itoa(pagination.Page - 1)})
+		}
+		add("first", map[string]string{"page": "1"})
+		add("last", map[string]string{"page": strconv.Itoa(last)})
+		return links
+	}
+
+	if pagination.NextCursor != "" {
+		add("next", map[string]string{"before": pagination.NextCursor})
+	}
+	if pagination.PrevCursor != "" {
+		add("prev", map[string]string{"after": pagination.PrevCursor})
+	}
+	return links
+}
+
+// NewServer only trusts X-Forwarded-For for client IPs when TRUST_PROXY is
+// true, i.e. when it runs behind a proxy that sets the header.
 func NewServer(routes []Route) *echo.Echo {
 	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	if os.Getenv(trustProxyEnv) == "true" {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
 	i := 0
 	for i < len(routes) {
 		route := routes[i]
@@ -49,22 +143,54 @@ func StartServer(e *echo.Echo) error {
 	return e.Start(":" + os.Getenv("PORT"))
 }
 
-func NewPaginator(c echo.Context) func(*gorm.DB) *gorm.DB {
-	return func(db *gorm.DB) *gorm.DB {
-		page, _ := strconv.Atoi(c.QueryParam("page"))
-		if page == 0 {
-			page = 1
-		}
+func ParseIDParam(c echo.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	return uint(id), err
+}
 
-		pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
-		switch {
-		case pageSize > 100:
-			pageSize = 100
-		case pageSize <= 0:
-			pageSize = 10
-		}
+type Paginator struct {
+	Page     int
+	PageSize int
+}
+
+func NewPaginator(c echo.Context) *Paginator {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page <= 0 {
+		page = 1
+	}
+	return &Paginator{Page: page, PageSize: parsePageSize(c)}
+}
+
+func (paginator *Paginator) Scope(db *gorm.DB) *gorm.DB {
+	offset := (paginator.Page - 1) * paginator.PageSize
+	return db.Offset(offset).Limit(paginator.PageSize)
+}
+
+// Find counts every row matched by db, loads the requested page into dest and
+// describes the page for NewPaginatedResponse.
+func (paginator *Paginator) Find(db *gorm.DB, dest interface{}) (*Pagination, error) {
+	var total int64
+	if err := db.Session(&gorm.Session{}).Model(dest).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Session(&gorm.Session{}).Scopes(paginator.Scope).Find(dest).Error; err != nil {
+		return nil, err
+	}
+	return &Pagination{
+		Page:     paginator.Page,
+		PageSize: paginator.PageSize,
+		Total:    total,
+		HasMore:  int64(paginator.Page*paginator.PageSize) < total,
+	}, nil
+}
 
-		offset := (page - 1) * pageSize
-		return db.Offset(offset).Limit(pageSize)
+func parsePageSize(c echo.Context) int {
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	switch {
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	case pageSize <= 0:
+		pageSize = defaultPageSize
 	}
+	return pageSize
 }
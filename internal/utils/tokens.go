@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const (
+	secretTokenBytes = 32
+
+	apiKeyPrefix      = "nmb_" // makes leaked keys easy to recognize, e.g. by secret scanners
+	apiKeyShownLength = 12
+)
+
+// NewSecretToken returns an opaque random token for the client, e.g. a refresh
+// or password reset token, and the hash to store in its place.
+func NewSecretToken() (string, string, error) {
+	b := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashSecretToken(token), nil
+}
+
+// HashSecretToken needs no salt or stretching since secret tokens are long
+// and random, unlike passwords.
+func HashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey returns a key for the user to copy, the start of it that may be
+// shown again later, and the hash to store in its place.
+func NewAPIKey() (string, string, string, error) {
+	token, _, err := NewSecretToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key := apiKeyPrefix + token
+	return key, key[:apiKeyShownLength], HashSecretToken(key), nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer    = "Nimble"
+	totpPeriod    = 30 // seconds
+	totpSkew      = 1  // periods of clock drift allowed either way
+	recoveryCodes = 10
+)
+
+// NewTOTPKey generates a secret for the user to add to their authenticator app.
+func NewTOTPKey(accountName string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+	})
+}
+
+// ValidateTOTP returns the time step the code is valid for, or 0 when it is
+// not valid for any step within the allowed skew. Callers must only accept a
+// step once, so that a seen code cannot be replayed.
+func ValidateTOTP(secret, code string, now time.Time) int64 {
+	code = strings.TrimSpace(code)
+	step := now.Unix() / totpPeriod
+	i := step - totpSkew
+	for i <= step+totpSkew {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(i*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return i
+		}
+		i++
+	}
+	return 0
+}
+
+// NewRecoveryCodes returns codes for the user to write down, formatted like
+// "abcde-fghij", and the hashes to store in their place.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	i := 0
+	for i < recoveryCodes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = HashRecoveryCode(codes[i])
+		i++
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode ignores case, spaces and dashes, which are easily mistyped.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashSecretToken(code)
+}